@@ -23,22 +23,56 @@ type Call struct {
 	Reply         interface{}
 	Done          chan *Call
 	Error         error
+	metadata      map[string]string // sent in the request header
+	timeout       time.Duration     // time left until the deadline of the caller's context
+	stream        streamReceiver    // takes the replies of a streaming call
+	window        *sendWindow       // arguments of a streaming call the server has room for
 }
 
 func (call *Call) done() {
+	// a sender waiting for room fails instead of waiting forever
+	call.window.close()
 	call.Done <- call
 }
 
 type Client struct {
-	seq      uint64
-	cp       encode.CodeProcess
-	option   *Option
-	header   encode.Header
-	sendLock sync.Mutex // protect send
-	mu       sync.Mutex // Client may be used by multiple goroutines
-	calling  map[uint64]*Call
-	closing  bool
-	shutdown bool
+	seq          uint64
+	cp           encode.CodeProcess
+	option       *Option
+	capabilities []string // negotiated with the server
+	header       encode.Header
+	sendLock     sync.Mutex // protect send
+	mu           sync.Mutex // Client may be used by multiple goroutines
+	calling      map[uint64]*Call
+	closing      bool
+	shutdown     bool
+	draining     bool // the server sent encode.KindGoAway,no new calls are sent
+	// interceptors run around Call and GoCall
+	interceptors []ClientInterceptor
+}
+
+// Invoker sends a call and waits for its reply
+type Invoker func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// ClientInterceptor runs around a call,it may change the arguments before calling
+// invoker and sees the final Call.Error returned by it
+type ClientInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error
+
+// ChainClientInterceptors wraps invoker with interceptors,the first one runs outermost
+func ChainClientInterceptors(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return invoker
+}
+
+// Use adds interceptors around Call and GoCall,the first one added runs outermost.
+// Call it before the client is used.
+func (client *Client) Use(interceptors ...ClientInterceptor) {
+	client.interceptors = append(client.interceptors, interceptors...)
 }
 
 var ErrorShutdown = errors.New("connection is shut down")
@@ -60,7 +94,7 @@ var _ io.Closer = (*Client)(nil)
 func (client *Client) registerCall(call *Call) (uint64, error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	if client.closing || client.shutdown {
+	if client.closing || client.shutdown || client.draining {
 		return 0, ErrorShutdown
 	}
 	call.Seq = client.seq
@@ -77,6 +111,25 @@ func (client *Client) removeCall(seq uint64) *Call {
 	return call
 }
 
+// findCall returns the call seq without removing it
+func (client *Client) findCall(seq uint64) *Call {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.calling[seq]
+}
+
+// cancelCall gives up on the call seq,the server is told to stop working on it
+func (client *Client) cancelCall(seq uint64) {
+	call := client.removeCall(seq)
+	if call == nil {
+		return
+	}
+	call.window.close()
+	if client.Supports(CapabilityCancel) {
+		go client.sendCancel(seq)
+	}
+}
+
 func (client *Client) terminateCalls(err error) {
 	client.sendLock.Lock()
 	defer client.sendLock.Unlock()
@@ -90,28 +143,85 @@ func (client *Client) terminateCalls(err error) {
 	}
 }
 
+// Supports reports whether both the client and the server support capability
+func (client *Client) Supports(capability string) bool {
+	return hasCapability(client.capabilities, capability)
+}
+
 // IsAvailable return true if the client is available
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.draining
+}
+
+// PendingCalls return the number of calls waiting for their response
+func (client *Client) PendingCalls() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return len(client.calling)
+}
+
+// IsDraining return true once the server is shutting down,
+// calls already sent still complete and the client closes itself after them
+func (client *Client) IsDraining() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.draining
+}
+
+// drained reports whether a draining client has no calls left
+func (client *Client) drained() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.draining && len(client.calling) == 0
 }
 
 func (client *Client) receive() {
 	var err error
 	// if err,break
 	for err == nil {
+		// the server closes the connection of a drained client as well,don't wait for it
+		if client.drained() {
+			_ = client.Close()
+		}
 		var h encode.Header
 		if err = client.cp.ReadHeader(&h); err != nil {
 			break
 		}
+		if h.Kind == encode.KindStream {
+			err = client.receiveStream(&h)
+			continue
+		}
+		// the server is shutting down,calls already sent are still answered
+		if h.Kind == encode.KindGoAway {
+			client.mu.Lock()
+			client.draining = true
+			client.mu.Unlock()
+			err = client.cp.ReadBody(nil)
+			continue
+		}
+		// the server has room for more arguments of a stream
+		if h.Kind == encode.KindWindow {
+			if call := client.findCall(h.Seq); call != nil {
+				call.window.grant(int(h.Credit))
+			}
+			err = client.cp.ReadBody(nil)
+			continue
+		}
 		call := client.removeCall(h.Seq)
 		switch {
 		case call == nil:
 			err = client.cp.ReadBody(nil)
 		// call exist,but server errors
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = responseError(h.Error)
+			// the server is shutting down,even if it can't send encode.KindGoAway
+			if call.Error == ErrServerShutdown {
+				client.mu.Lock()
+				client.draining = true
+				client.mu.Unlock()
+			}
 			err = client.cp.ReadBody(nil)
 			call.done()
 		default:
@@ -126,6 +236,33 @@ func (client *Client) receive() {
 	client.terminateCalls(err)
 }
 
+// responseError turns the error of a response back into an error,
+// well-known errors of the server become their sentinel
+func responseError(message string) error {
+	switch message {
+	case ErrServerBusy.Error():
+		return ErrServerBusy
+	case ErrServerShutdown.Error():
+		return ErrServerShutdown
+	}
+	return errors.New(message)
+}
+
+// receiveStream hands one reply of a streaming call to its receiver,
+// the call stays registered until the response ending the stream
+func (client *Client) receiveStream(h *encode.Header) error {
+	call := client.findCall(h.Seq)
+	if call == nil || call.stream == nil {
+		return client.cp.ReadBody(nil)
+	}
+	reply := call.stream.newMessage()
+	if err := client.cp.ReadBody(reply); err != nil {
+		return err
+	}
+	call.stream.push(reply)
+	return nil
+}
+
 func (client *Client) send(call *Call) {
 	// make sure that the client will send a complete request
 	client.sendLock.Lock()
@@ -142,6 +279,8 @@ func (client *Client) send(call *Call) {
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Metadata = call.metadata
+	client.header.Timeout = call.timeout
 	// encode and send the request
 	if err := client.cp.Write(&client.header, call.Args); err != nil {
 		// err
@@ -155,6 +294,28 @@ func (client *Client) send(call *Call) {
 	}
 }
 
+// sendMessage sends a message belonging to a call that was already sent,e.g. a control message.
+// A draining client still sends them,the calls in flight are allowed to finish.
+func (client *Client) sendMessage(header *encode.Header, body interface{}) error {
+	client.mu.Lock()
+	closed := client.closing || client.shutdown
+	client.mu.Unlock()
+	if closed {
+		return ErrorShutdown
+	}
+	client.sendLock.Lock()
+	defer client.sendLock.Unlock()
+	return client.cp.Write(header, body)
+}
+
+// sendCancel sends a cancel message for the call seq
+func (client *Client) sendCancel(seq uint64) {
+	header := &encode.Header{Seq: seq, Kind: encode.KindCancel}
+	if err := client.sendMessage(header, struct{}{}); err != nil && err != ErrorShutdown {
+		log.Println("rpc client: send cancel error:", err)
+	}
+}
+
 // GoCall invokes the function asynchronously.
 func (client *Client) GoCall(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
@@ -168,17 +329,52 @@ func (client *Client) GoCall(serviceMethod string, args, reply interface{}, done
 		Reply:         reply,
 		Done:          done,
 	}
-	client.send(call)
+	if len(client.interceptors) == 0 {
+		client.send(call)
+		return call
+	}
+	// interceptors may block,run them in the background
+	// Seq of the returned call stays 0
+	go func() {
+		call.Error = ChainClientInterceptors(client.interceptors, client.invoke)(context.Background(), serviceMethod, args, reply)
+		call.done()
+	}()
 	return call
 }
 
 // Call invokes the named function, waits for it to complete,
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.GoCall(serviceMethod, args, reply, make(chan *Call, 1))
+	return ChainClientInterceptors(client.interceptors, client.invoke)(ctx, serviceMethod, args, reply)
+}
+
+// newCall prepares a call carrying the metadata and the deadline of ctx
+func (client *Client) newCall(ctx context.Context, serviceMethod string, args, reply interface{}) *Call {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		metadata:      outgoingMetadata(ctx),
+	}
+	// the server stops the request once the caller can't use the reply anymore
+	if deadline, ok := ctx.Deadline(); ok {
+		call.timeout = time.Until(deadline)
+	}
+	return call
+}
+
+// invoke sends the call and waits for it,without interceptors
+func (client *Client) invoke(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return errors.New("rpc client: call failed: " + err.Error())
+	}
+	call := client.newCall(ctx, serviceMethod, args, reply)
+	client.send(call)
 	select {
 	// ctx, _ := context.WithTimeout(context.Background(), time.Second)
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
+		// tell the server to stop working on a call still waiting for its reply
+		client.cancelCall(call.Seq)
 		return errors.New("rpc client: call failed: " + ctx.Err().Error())
 	case call := <-call.Done:
 		return call.Error
@@ -202,28 +398,79 @@ func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
 }
 
 func NewClient(connect net.Conn, option *Option) (*Client, error) {
-	f := encode.NewCodeProcessMap[option.EncodingType]
-	if f == nil {
-		err := fmt.Errorf("invalid codec type %s", option.EncodingType)
-		log.Println("rpc client: encode error:", err)
-		return nil, err
-	}
 	// send options to server
 	if err := json.NewEncoder(connect).Encode(option); err != nil {
 		log.Println("rpc client: options error: ", err)
 		_ = connect.Close()
 		return nil, err
 	}
+	var conn io.ReadWriteCloser = connect
+	negotiation := new(Negotiation)
+	// wait for the server to agree on version and capabilities
+	if option.Version > 0 {
+		_ = connect.SetReadDeadline(time.Now().Add(option.negotiationTimeout()))
+		decoder := json.NewDecoder(connect)
+		err := decoder.Decode(negotiation)
+		_ = connect.SetReadDeadline(time.Time{})
+		if err != nil {
+			_ = connect.Close()
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, errNoNegotiation
+			}
+			log.Println("rpc client: negotiation error: ", err)
+			return nil, err
+		}
+		if err := negotiation.check(option); err != nil {
+			log.Println("rpc client: negotiation error:", err)
+			_ = connect.Close()
+			return nil, err
+		}
+		conn = newHandshakeConn(connect, decoder)
+	}
+	cp, err := newCodeProcess(conn, option)
+	if err != nil {
+		log.Println("rpc client: encode error:", err)
+		_ = connect.Close()
+		return nil, err
+	}
 	client := &Client{
-		seq:     1, // 0: invalid call
-		cp:      f(connect),
-		option:  option,
-		calling: make(map[uint64]*Call),
+		seq:          1, // 0: invalid call
+		cp:           cp,
+		option:       option,
+		capabilities: negotiation.Capabilities,
+		calling:      make(map[uint64]*Call),
 	}
 	go client.receive()
 	return client, nil
 }
 
+// negotiationTimeout is how long a client waits for the Negotiation of the server,
+// at most half of Option.ConnectTimeout so there is time left to dial again without it
+const negotiationTimeout = time.Second
+
+// errNoNegotiation :the server didn't answer the option,it is older than Option.Version
+var errNoNegotiation = errors.New("rpc client: server sent no negotiation")
+
+func (option *Option) negotiationTimeout() time.Duration {
+	if option.ConnectTimeout > 0 && option.ConnectTimeout/2 < negotiationTimeout {
+		return option.ConnectTimeout / 2
+	}
+	return negotiationTimeout
+}
+
+// withoutNegotiation returns option for a server older than option.Version,
+// nil if option needs a capability such a server doesn't have
+func (option *Option) withoutNegotiation() *Option {
+	if len(option.requiredCapabilities()) > 0 {
+		return nil
+	}
+	legacy := *option
+	legacy.Version = 0
+	legacy.Capabilities = nil
+	return &legacy
+}
+
 func parseOptions(options ...*Option) (*Option, error) {
 	// options is nil
 	// pass nil as parameter
@@ -235,6 +482,12 @@ func parseOptions(options ...*Option) (*Option, error) {
 	}
 	option := options[0]
 	option.RPCNumber = DefaultOption.RPCNumber
+	if option.Version == 0 {
+		option.Version = DefaultOption.Version
+	}
+	if option.Capabilities == nil {
+		option.Capabilities = DefaultOption.Capabilities
+	}
 	if option.EncodingType == "" {
 		option.EncodingType = DefaultOption.EncodingType
 	}
@@ -270,11 +523,26 @@ type clientStatus struct {
 //newClientFunc implement different dial by different ClientFunc
 type newClientFunc func(conn net.Conn, opt *Option) (client *Client, err error)
 
-func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
+func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (*Client, error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
+	client, err := dialOption(f, network, address, opt)
+	// an old server during a rolling upgrade
+	if err == errNoNegotiation {
+		legacy := opt.withoutNegotiation()
+		if legacy == nil {
+			return nil, fmt.Errorf("rpc client: server does not negotiate, it supports none of %v", opt.requiredCapabilities())
+		}
+		log.Println("rpc client: no negotiation from", address, "dial again without it")
+		return dialOption(f, network, address, legacy)
+	}
+	return client, err
+}
+
+// dialOption :dialTimeout with parsed options
+func dialOption(f newClientFunc, network, address string, opt *Option) (client *Client, err error) {
 	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
 	if err != nil {
 		return nil, err
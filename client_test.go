@@ -0,0 +1,165 @@
+package MicroRPC
+
+import (
+	"MicroRPC/encode"
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// dial connects to addr,the client is closed at the end of the test
+func dial(t *testing.T, addr string, option *Option) *Client {
+	t.Helper()
+	client, err := GeneralDial(addr, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestCallOptions(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	_ = server.RegisterProto(new(ProtoEcho))
+	addr := startServer(t, server)
+	for _, encodingType := range []encode.Type{encode.GobType, encode.JsonType, encode.MsgpackType} {
+		for _, compressType := range []encode.CompressType{"", encode.GzipCompress, encode.FlateCompress} {
+			t.Run(string(encodingType)+"/"+string(compressType), func(t *testing.T) {
+				client := dial(t, addr, &Option{EncodingType: encodingType, CompressType: compressType})
+				var reply int
+				if err := client.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+					t.Fatalf("Sum = %d, %v", reply, err)
+				}
+			})
+		}
+	}
+	t.Run(string(encode.ProtobufType), func(t *testing.T) {
+		client := dial(t, addr, &Option{EncodingType: encode.ProtobufType, CompressType: encode.GzipCompress})
+		reply := new(wrapperspb.StringValue)
+		if err := client.Call(context.Background(), "ProtoEcho.Echo", wrapperspb.String("hi"), reply); err != nil || reply.Value != "hi" {
+			t.Fatalf("Echo = %q, %v", reply.Value, err)
+		}
+	})
+}
+
+func TestCallUnknownMethod(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	addr := startServer(t, server)
+	for _, framing := range []bool{false, true} {
+		for _, encodingType := range []encode.Type{encode.GobType, encode.JsonType, encode.MsgpackType} {
+			client := dial(t, addr, &Option{EncodingType: encodingType, Framing: framing, CompressType: encode.GzipCompress})
+			var reply int
+			if err := client.Call(context.Background(), "Arith.Missing", Args{Num1: 1, Num2: 2}, &reply); err == nil {
+				t.Fatalf("%s framing %v: unknown method succeeded", encodingType, framing)
+			}
+			// the body of the unknown method was skipped,the connection is still in sync
+			if err := client.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+				t.Fatalf("%s framing %v: Sum = %d, %v", encodingType, framing, reply, err)
+			}
+		}
+	}
+}
+
+// startOldServer serves Arith.Sum like a server from before the negotiation,
+// it reads the option and answers requests but never sends a Negotiation
+func startOldServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				decoder := json.NewDecoder(conn)
+				var option Option
+				if err := decoder.Decode(&option); err != nil {
+					return
+				}
+				cp := encode.NewGobCodeProcess(newHandshakeConn(conn, decoder))
+				for {
+					var header encode.Header
+					if err := cp.ReadHeader(&header); err != nil {
+						return
+					}
+					var args Args
+					if err := cp.ReadBody(&args); err != nil {
+						return
+					}
+					_ = cp.Write(&header, args.Num1+args.Num2)
+				}
+			}()
+		}
+	}()
+	return "tcp@" + l.Addr().String()
+}
+
+func TestDialOldServer(t *testing.T) {
+	addr := startOldServer(t)
+	client := dial(t, addr, &Option{ConnectTimeout: time.Second})
+	if client.option.Version != 0 || client.Supports(CapabilityCompress) {
+		t.Fatalf("version = %d, capabilities = %v, expect version 0 without capabilities", client.option.Version, client.capabilities)
+	}
+	var reply int
+	if err := client.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("Sum = %d, %v", reply, err)
+	}
+	// an old server can't compress
+	if client, err := GeneralDial(addr, &Option{ConnectTimeout: time.Second, CompressType: encode.GzipCompress}); err == nil {
+		_ = client.Close()
+		t.Fatal("dial with compression succeeded, expect an error")
+	}
+}
+
+func TestDialNegotiates(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	client := dial(t, startServer(t, server), &Option{CompressType: encode.GzipCompress})
+	if client.option.Version != DefaultOption.Version || !client.Supports(CapabilityCompress) {
+		t.Fatalf("version = %d, capabilities = %v, expect %s negotiated", client.option.Version, client.capabilities, CapabilityCompress)
+	}
+}
+
+func TestClientInterceptors(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	client := dial(t, startServer(t, server), nil)
+	var calls []error
+	var mu sync.Mutex
+	client.Use(func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error {
+		// the interceptor may change the arguments and sees the result
+		a := args.(Args)
+		a.Num2 *= 10
+		err := invoker(ctx, serviceMethod, a, reply)
+		mu.Lock()
+		calls = append(calls, err)
+		mu.Unlock()
+		return err
+	})
+	var reply int
+	if err := client.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 21 {
+		t.Fatalf("Sum = %d, %v, expect 21", reply, err)
+	}
+	call := <-client.GoCall("Arith.Missing", Args{}, &reply, make(chan *Call, 1)).Done
+	if call.Error == nil {
+		t.Fatal("GoCall of a missing method succeeded")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 2 || calls[0] != nil || calls[1] == nil {
+		t.Fatalf("interceptor saw %v", calls)
+	}
+}
@@ -1,13 +1,32 @@
 package encode
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type Header struct {
 	Seq           uint64
 	ServiceMethod string
 	Error         string
+	Metadata      map[string]string // request metadata,e.g. request id or auth token
+	Kind          Kind
+	Timeout       time.Duration // time left before the client gives up,0 means no deadline
+	Credit        uint32        // KindWindow: number of further stream messages the peer may send
 }
 
+// Kind tells control messages apart from requests and responses
+type Kind uint8
+
+const (
+	KindCall      Kind = iota // a request or its response
+	KindCancel                // the client gave up on the request Seq
+	KindStream                // one message of a stream,replies of the server end with the response
+	KindStreamEnd             // the client finished sending on the stream Seq
+	KindWindow                // the receiver of a stream grants Credit more messages
+	KindGoAway                // the server is shutting down,no new requests are accepted
+)
+
 type CodeProcess interface {
 	io.Closer
 	ReadHeader(*Header) error
@@ -17,15 +36,38 @@ type CodeProcess interface {
 
 type NewCodeProcess func(io.ReadWriteCloser) CodeProcess
 
+// Serializer encodes a single value apart from the stream,
+// used when a body has to be handled as bytes, e.g. to compress it
+type Serializer interface {
+	Marshal(interface{}) ([]byte, error)
+	// Unmarshal :the value must be a pointer
+	Unmarshal([]byte, interface{}) error
+}
+
 type Type string
 
 const (
-	GobType Type = "application/gob"
+	GobType      Type = "application/gob"
+	JsonType     Type = "application/json"
+	ProtobufType Type = "application/protobuf"
+	MsgpackType  Type = "application/msgpack"
 )
 
 var NewCodeProcessMap map[Type]NewCodeProcess
 
+// SerializerMap :encoding types without a Serializer can't be compressed
+var SerializerMap map[Type]Serializer
+
 func init() {
 	NewCodeProcessMap = make(map[Type]NewCodeProcess)
 	NewCodeProcessMap[GobType] = NewGobCodeProcess
+	NewCodeProcessMap[JsonType] = NewJsonCodeProcess
+	NewCodeProcessMap[ProtobufType] = NewProtobufCodeProcess
+	NewCodeProcessMap[MsgpackType] = NewMsgpackCodeProcess
+
+	SerializerMap = make(map[Type]Serializer)
+	SerializerMap[GobType] = gobSerializer{}
+	SerializerMap[JsonType] = jsonSerializer{}
+	SerializerMap[ProtobufType] = protobufSerializer{}
+	SerializerMap[MsgpackType] = msgpackSerializer{}
 }
@@ -0,0 +1,113 @@
+package encode
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// bufferConn is an in-memory connection,what is written is read back
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (c *bufferConn) Close() error {
+	return nil
+}
+
+type testBody struct {
+	Name string
+	Nums []int
+}
+
+// codeProcessTests has one body per encoding type,reply is what the body is decoded into
+var codeProcessTests = []struct {
+	typ   Type
+	body  interface{}
+	reply func() interface{}
+}{
+	{GobType, testBody{Name: "gob", Nums: []int{1, 2}}, func() interface{} { return new(testBody) }},
+	{JsonType, testBody{Name: "json", Nums: []int{1, 2}}, func() interface{} { return new(testBody) }},
+	{ProtobufType, wrapperspb.String("protobuf"), func() interface{} { return new(wrapperspb.StringValue) }},
+	{MsgpackType, testBody{Name: "msgpack", Nums: []int{1, 2}}, func() interface{} { return new(testBody) }},
+	{MsgpackType, &msgBody{Num: 42}, func() interface{} { return new(msgBody) }},
+}
+
+// msgBody takes the MsgMarshaler/MsgUnmarshaler fast path of msgpack,encoded as a bare int
+type msgBody struct {
+	Num int
+}
+
+func (b *msgBody) MarshalMsg(buf []byte) ([]byte, error) {
+	data, err := msgpack.Marshal(b.Num)
+	return append(buf, data...), err
+}
+
+func (b *msgBody) UnmarshalMsg(buf []byte) ([]byte, error) {
+	return nil, msgpack.Unmarshal(buf, &b.Num)
+}
+
+// equalBody reports whether reply points to body
+func equalBody(reply, body interface{}) bool {
+	if m, ok := body.(proto.Message); ok {
+		return proto.Equal(m, reply.(proto.Message))
+	}
+	if reflect.TypeOf(body) == reflect.TypeOf(reply) {
+		return reflect.DeepEqual(reply, body)
+	}
+	return reflect.DeepEqual(reflect.ValueOf(reply).Elem().Interface(), body)
+}
+
+func TestCodeProcessRoundTrip(t *testing.T) {
+	for _, test := range codeProcessTests {
+		t.Run(string(test.typ), func(t *testing.T) {
+			conn := new(bufferConn)
+			cp := NewCodeProcessMap[test.typ](conn)
+			header := &Header{ServiceMethod: "Foo.Sum", Seq: 7, Error: "oops", Metadata: map[string]string{"user": "u1"}, Kind: KindCancel, Timeout: time.Second}
+			// the first body is skipped,the second one decoded
+			for i := 0; i < 2; i++ {
+				if err := cp.Write(header, test.body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 2; i++ {
+				var h Header
+				if err := cp.ReadHeader(&h); err != nil {
+					t.Fatal(err)
+				}
+				if h.ServiceMethod != header.ServiceMethod || h.Seq != header.Seq || h.Error != header.Error || h.Kind != header.Kind || h.Timeout != header.Timeout ||
+					!reflect.DeepEqual(h.Metadata, header.Metadata) {
+					t.Fatalf("header = %+v, expect %+v", h, *header)
+				}
+				if i == 0 {
+					if err := cp.ReadBody(nil); err != nil {
+						t.Fatal("skip body:", err)
+					}
+					continue
+				}
+				reply := test.reply()
+				if err := cp.ReadBody(reply); err != nil {
+					t.Fatal(err)
+				}
+				if !equalBody(reply, test.body) {
+					t.Fatalf("body = %+v, expect %+v", reply, test.body)
+				}
+			}
+		})
+	}
+}
+
+func TestProtobufFrameTooLarge(t *testing.T) {
+	conn := new(bufferConn)
+	conn.Write(protowire.AppendVarint(nil, 1<<62))
+	var h Header
+	if err := NewProtobufCodeProcess(conn).ReadHeader(&h); err != errProtobufFrameTooLarge {
+		t.Fatalf("ReadHeader = %v, expect %v", err, errProtobufFrameTooLarge)
+	}
+}
@@ -0,0 +1,162 @@
+package encode
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+)
+
+type CompressType string
+
+const (
+	GzipCompress  CompressType = "gzip"
+	FlateCompress CompressType = "flate"
+)
+
+// Compressor compresses one body at a time
+type Compressor interface {
+	Compress([]byte) ([]byte, error)
+	// Decompress fails for bodies decompressing to more than MaxDecompressedLength bytes
+	Decompress([]byte) ([]byte, error)
+}
+
+// MaxDecompressedLength caps the size of a decompressed body,
+// so a small body can't expand to whatever memory the process has
+const MaxDecompressedLength = 1 << 26
+
+var errDecompressedTooLarge = errors.New("rpc encoding: decompressed body too large")
+
+// readDecompressed reads r to the end,at most MaxDecompressedLength bytes
+func readDecompressed(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxDecompressedLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedLength {
+		return nil, errDecompressedTooLarge
+	}
+	return data, nil
+}
+
+// CompressorMap :register other algorithms here before dialing or serving
+var CompressorMap map[CompressType]Compressor
+
+func init() {
+	CompressorMap = make(map[CompressType]Compressor)
+	CompressorMap[GzipCompress] = gzipCompressor{}
+	CompressorMap[FlateCompress] = flateCompressor{}
+}
+
+// the first byte of every body tells whether the rest is compressed
+const (
+	rawBody byte = iota
+	compressedBody
+)
+
+// CompressCodeProcess serializes bodies itself and compresses those of at least
+// threshold bytes, the wrapped CodeProcess carries headers and the resulting bytes
+type CompressCodeProcess struct {
+	CodeProcess
+	serializer Serializer
+	compressor Compressor
+	threshold  int
+}
+
+// ReadBody :a nil body is still read through the wrapped CodeProcess to skip it
+func (c *CompressCodeProcess) ReadBody(body interface{}) error {
+	var payload []byte
+	if err := c.CodeProcess.ReadBody(&payload); err != nil || body == nil {
+		return err
+	}
+	if len(payload) == 0 {
+		return errors.New("rpc encoding: compressed body is empty")
+	}
+	data := payload[1:]
+	if payload[0] == compressedBody {
+		var err error
+		if data, err = c.compressor.Decompress(data); err != nil {
+			return err
+		}
+	}
+	return c.serializer.Unmarshal(data, body)
+}
+
+func (c *CompressCodeProcess) Write(header *Header, body interface{}) error {
+	data, err := c.serializer.Marshal(body)
+	if err == nil && len(data) >= c.threshold {
+		var compressed []byte
+		if compressed, err = c.compressor.Compress(data); err == nil {
+			return c.CodeProcess.Write(header, append([]byte{compressedBody}, compressed...))
+		}
+	}
+	if err != nil {
+		log.Println("rpc encoding: compress error encoding body:", err)
+		_ = c.Close()
+		return err
+	}
+	return c.CodeProcess.Write(header, append([]byte{rawBody}, data...))
+}
+
+var _ CodeProcess = (*CompressCodeProcess)(nil)
+
+func NewCompressCodeProcess(cp CodeProcess, serializer Serializer, compressor Compressor, threshold int) CodeProcess {
+	return &CompressCodeProcess{
+		CodeProcess: cp,
+		serializer:  serializer,
+		compressor:  compressor,
+		threshold:   threshold,
+	}
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	w := gzip.NewWriter(&buffer)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	return readDecompressed(r)
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	w, err := flate.NewWriter(&buffer, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (flateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer func() {
+		_ = r.Close()
+	}()
+	return readDecompressed(r)
+}
@@ -0,0 +1,93 @@
+package encode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompressCodeProcessRoundTrip(t *testing.T) {
+	for _, compressType := range []CompressType{GzipCompress, FlateCompress} {
+		for _, test := range codeProcessTests {
+			t.Run(string(compressType)+"/"+string(test.typ), func(t *testing.T) {
+				conn := new(bufferConn)
+				cp := NewCompressCodeProcess(NewCodeProcessMap[test.typ](conn), SerializerMap[test.typ],
+					CompressorMap[compressType], 0)
+				header := &Header{ServiceMethod: "Foo.Sum", Seq: 7}
+				for i := 0; i < 2; i++ {
+					if err := cp.Write(header, test.body); err != nil {
+						t.Fatal(err)
+					}
+				}
+				var h Header
+				if err := cp.ReadHeader(&h); err != nil || h.Seq != header.Seq {
+					t.Fatalf("header = %+v, %v", h, err)
+				}
+				if err := cp.ReadBody(nil); err != nil {
+					t.Fatal("skip body:", err)
+				}
+				if err := cp.ReadHeader(&h); err != nil || h.Seq != header.Seq {
+					t.Fatalf("header = %+v, %v", h, err)
+				}
+				reply := test.reply()
+				if err := cp.ReadBody(reply); err != nil {
+					t.Fatal(err)
+				}
+				if !equalBody(reply, test.body) {
+					t.Fatalf("body = %+v, expect %+v", reply, test.body)
+				}
+			})
+		}
+	}
+}
+
+func TestCompressThreshold(t *testing.T) {
+	body := testBody{Name: strings.Repeat("a", 1000)}
+	for _, test := range []struct {
+		threshold  int
+		compressed bool
+	}{{100, true}, {10000, false}} {
+		conn := new(bufferConn)
+		cp := NewCompressCodeProcess(NewJsonCodeProcess(conn), jsonSerializer{}, gzipCompressor{}, test.threshold)
+		if err := cp.Write(&Header{}, body); err != nil {
+			t.Fatal(err)
+		}
+		if compressed := conn.Len() < 500; compressed != test.compressed {
+			t.Fatalf("threshold %d: %d bytes written, compressed %v", test.threshold, conn.Len(), compressed)
+		}
+		var reply testBody
+		if err := cp.ReadHeader(new(Header)); err != nil {
+			t.Fatal(err)
+		}
+		if err := cp.ReadBody(&reply); err != nil || reply.Name != body.Name {
+			t.Fatalf("threshold %d: body %d bytes, %v", test.threshold, len(reply.Name), err)
+		}
+	}
+}
+
+func TestDecompressTooLarge(t *testing.T) {
+	// a few KiB expanding past the limit
+	data := make([]byte, MaxDecompressedLength+1)
+	for _, compressType := range []CompressType{GzipCompress, FlateCompress} {
+		compressor := CompressorMap[compressType]
+		compressed, err := compressor.Compress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := compressor.Decompress(compressed); err != errDecompressedTooLarge {
+			t.Fatalf("%s: Decompress = %v, expect %v", compressType, err, errDecompressedTooLarge)
+		}
+		if got, err := compressor.Decompress(mustCompress(t, compressor, data[1:])); err != nil || !bytes.Equal(got, data[1:]) {
+			t.Fatalf("%s: Decompress at the limit = %d bytes, %v", compressType, len(got), err)
+		}
+	}
+}
+
+func mustCompress(t *testing.T, compressor Compressor, data []byte) []byte {
+	t.Helper()
+	compressed, err := compressor.Compress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compressed
+}
@@ -0,0 +1,209 @@
+package encode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+)
+
+// Every frame starts with a fixed prefix, all integers big endian:
+//
+//	magic(2) version(1) flags(1) header length(4) body length(4) checksum(4) prefix checksum(4)
+//
+// followed by the serialized header and body. The checksum is the CRC-32 (IEEE)
+// of header and body, the prefix checksum the CRC-32 of the prefix before it,
+// so corrupt lengths are caught before anything is read on their behalf.
+// Readers can route on the header alone and skip the body without decoding it.
+const (
+	FrameMagic      uint16 = 0x4d52 // "MR"
+	FrameVersion    uint8  = 1
+	FramePrefixSize        = 20
+
+	MaxFrameHeaderLength = 1 << 16
+	MaxFrameBodyLength   = 1 << 26
+)
+
+// frame flags
+const (
+	FlagCompressed uint8 = 1 << iota // body is compressed with the negotiated Compressor
+)
+
+var errCorruptFrame = errors.New("rpc encoding: corrupt frame")
+
+// FramePrefix is the fixed size part of a frame
+type FramePrefix struct {
+	Version      uint8
+	Flags        uint8
+	HeaderLength uint32
+	BodyLength   uint32
+	Checksum     uint32
+}
+
+func (p *FramePrefix) marshal() []byte {
+	b := make([]byte, FramePrefixSize)
+	binary.BigEndian.PutUint16(b[0:], FrameMagic)
+	b[2] = p.Version
+	b[3] = p.Flags
+	binary.BigEndian.PutUint32(b[4:], p.HeaderLength)
+	binary.BigEndian.PutUint32(b[8:], p.BodyLength)
+	binary.BigEndian.PutUint32(b[12:], p.Checksum)
+	binary.BigEndian.PutUint32(b[16:], crc32.ChecksumIEEE(b[:16]))
+	return b
+}
+
+func (p *FramePrefix) unmarshal(b []byte) error {
+	if binary.BigEndian.Uint16(b[0:]) != FrameMagic || binary.BigEndian.Uint32(b[16:]) != crc32.ChecksumIEEE(b[:16]) {
+		return errCorruptFrame
+	}
+	p.Version = b[2]
+	p.Flags = b[3]
+	p.HeaderLength = binary.BigEndian.Uint32(b[4:])
+	p.BodyLength = binary.BigEndian.Uint32(b[8:])
+	p.Checksum = binary.BigEndian.Uint32(b[12:])
+	if p.Version != FrameVersion || p.HeaderLength > MaxFrameHeaderLength || p.BodyLength > MaxFrameBodyLength {
+		return errCorruptFrame
+	}
+	return nil
+}
+
+// FrameCodeProcess frames headers and bodies serialized by any registered Serializer.
+// A corrupt prefix is skipped byte by byte until the next intact one,
+// a frame whose header or body is corrupt is dropped as a whole.
+type FrameCodeProcess struct {
+	connect    io.ReadWriteCloser
+	reader     *bufio.Reader
+	buffer     *bufio.Writer
+	serializer Serializer
+	compressor Compressor // nil: never compress
+	threshold  int
+	flags      uint8  // flags of the frame whose body is pending
+	body       []byte // body of the last frame read by ReadHeader
+}
+
+// readFrame returns the next intact frame,skipping garbage and corrupt frames
+func (c *FrameCodeProcess) readFrame() (*FramePrefix, []byte, error) {
+	for {
+		b, err := c.reader.Peek(FramePrefixSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		var prefix FramePrefix
+		if err := prefix.unmarshal(b); err != nil {
+			// slide forward one byte until an intact prefix shows up,
+			// the lengths of a corrupt one can't be trusted
+			_, _ = c.reader.Discard(1)
+			continue
+		}
+		_, _ = c.reader.Discard(FramePrefixSize)
+		data := make([]byte, prefix.HeaderLength+prefix.BodyLength)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, nil, err
+		}
+		// the prefix is intact,so the frame ends where it says
+		if crc32.ChecksumIEEE(data) != prefix.Checksum {
+			log.Println("rpc encoding: frame checksum mismatch, frame dropped")
+			continue
+		}
+		return &prefix, data, nil
+	}
+}
+
+func (c *FrameCodeProcess) ReadHeader(h *Header) error {
+	for {
+		prefix, data, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+		if err := c.serializer.Unmarshal(data[:prefix.HeaderLength], h); err != nil {
+			log.Println("rpc encoding: frame error decoding header, frame dropped:", err)
+			continue
+		}
+		c.flags = prefix.Flags
+		c.body = data[prefix.HeaderLength:]
+		return nil
+	}
+}
+
+// ReadBody :a nil body drops the pending body without decoding it
+func (c *FrameCodeProcess) ReadBody(body interface{}) error {
+	data := c.body
+	c.body = nil
+	if body == nil {
+		return nil
+	}
+	if c.flags&FlagCompressed != 0 {
+		if c.compressor == nil {
+			return errors.New("rpc encoding: compressed frame without a compressor")
+		}
+		var err error
+		if data, err = c.compressor.Decompress(data); err != nil {
+			return err
+		}
+	}
+	return c.serializer.Unmarshal(data, body)
+}
+
+func (c *FrameCodeProcess) Close() error {
+	return c.connect.Close()
+}
+
+func (c *FrameCodeProcess) Write(header *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buffer.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	h, err := c.serializer.Marshal(header)
+	if err != nil {
+		log.Println("rpc encoding: frame error encoding header:", err)
+		return err
+	}
+	b, err := c.serializer.Marshal(body)
+	if err != nil {
+		log.Println("rpc encoding: frame error encoding body:", err)
+		return err
+	}
+	prefix := &FramePrefix{Version: FrameVersion}
+	if c.compressor != nil && len(b) >= c.threshold {
+		if b, err = c.compressor.Compress(b); err != nil {
+			log.Println("rpc encoding: frame error compressing body:", err)
+			return err
+		}
+		prefix.Flags |= FlagCompressed
+	}
+	if len(h) > MaxFrameHeaderLength || len(b) > MaxFrameBodyLength {
+		err = errors.New("rpc encoding: frame too large")
+		log.Println("rpc encoding: frame error encoding:", err)
+		return err
+	}
+	prefix.HeaderLength, prefix.BodyLength = uint32(len(h)), uint32(len(b))
+	checksum := crc32.NewIEEE()
+	_, _ = checksum.Write(h)
+	_, _ = checksum.Write(b)
+	prefix.Checksum = checksum.Sum32()
+	for _, p := range [][]byte{prefix.marshal(), h, b} {
+		if _, err := c.buffer.Write(p); err != nil {
+			log.Println("rpc encoding: frame error writing:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+var _ CodeProcess = (*FrameCodeProcess)(nil)
+
+// NewFrameCodeProcess :compressor may be nil
+func NewFrameCodeProcess(connect io.ReadWriteCloser, serializer Serializer, compressor Compressor, threshold int) CodeProcess {
+	return &FrameCodeProcess{
+		connect:    connect,
+		reader:     bufio.NewReader(connect),
+		buffer:     bufio.NewWriter(connect),
+		serializer: serializer,
+		compressor: compressor,
+		threshold:  threshold,
+	}
+}
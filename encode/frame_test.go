@@ -0,0 +1,107 @@
+package encode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameCodeProcessRoundTrip(t *testing.T) {
+	for _, compressor := range []Compressor{nil, gzipCompressor{}} {
+		for _, test := range codeProcessTests {
+			t.Run(string(test.typ), func(t *testing.T) {
+				conn := new(bufferConn)
+				cp := NewFrameCodeProcess(conn, SerializerMap[test.typ], compressor, 0)
+				header := &Header{ServiceMethod: "Foo.Sum", Seq: 7}
+				for i := 0; i < 2; i++ {
+					if err := cp.Write(header, test.body); err != nil {
+						t.Fatal(err)
+					}
+				}
+				var h Header
+				if err := cp.ReadHeader(&h); err != nil || h.Seq != header.Seq {
+					t.Fatalf("header = %+v, %v", h, err)
+				}
+				if err := cp.ReadBody(nil); err != nil {
+					t.Fatal("skip body:", err)
+				}
+				if err := cp.ReadHeader(&h); err != nil || h.Seq != header.Seq {
+					t.Fatalf("header = %+v, %v", h, err)
+				}
+				reply := test.reply()
+				if err := cp.ReadBody(reply); err != nil {
+					t.Fatal(err)
+				}
+				if !equalBody(reply, test.body) {
+					t.Fatalf("body = %+v, expect %+v", reply, test.body)
+				}
+			})
+		}
+	}
+}
+
+// writeFrames returns the frames of the requests Seq 1 to n
+func writeFrames(t *testing.T, n int) [][]byte {
+	t.Helper()
+	frames := make([][]byte, n)
+	for i := range frames {
+		conn := new(bufferConn)
+		cp := NewFrameCodeProcess(conn, jsonSerializer{}, nil, 0)
+		if err := cp.Write(&Header{ServiceMethod: "Foo.Sum", Seq: uint64(i + 1)}, testBody{Name: "frame"}); err != nil {
+			t.Fatal(err)
+		}
+		frames[i] = conn.Bytes()
+	}
+	return frames
+}
+
+func TestFrameCorrupt(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		corrupt func(frame []byte) []byte
+	}{
+		{"body", func(frame []byte) []byte {
+			frame[len(frame)-2] ^= 0xff
+			return frame
+		}},
+		{"header length", func(frame []byte) []byte {
+			frame[7] ^= 0x01
+			return frame
+		}},
+		// without the prefix checksum the reader would wait for 64 MiB that never come
+		{"body length", func(frame []byte) []byte {
+			frame[8] ^= 0x03
+			return frame
+		}},
+		{"garbage", func(frame []byte) []byte {
+			return append([]byte("garbage"), frame...)
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			frames := writeFrames(t, 3)
+			frames[1] = test.corrupt(frames[1])
+			conn := new(bufferConn)
+			conn.Write(bytes.Join(frames, nil))
+			cp := NewFrameCodeProcess(conn, jsonSerializer{}, nil, 0)
+			var seqs []uint64
+			for {
+				var h Header
+				if err := cp.ReadHeader(&h); err != nil {
+					break
+				}
+				var body testBody
+				if err := cp.ReadBody(&body); err != nil || body.Name != "frame" {
+					t.Fatalf("body = %+v, %v", body, err)
+				}
+				seqs = append(seqs, h.Seq)
+			}
+			// the garbage in front of an intact frame is skipped,a corrupt frame is dropped
+			expect := []uint64{1, 3}
+			if test.name == "garbage" {
+				expect = []uint64{1, 2, 3}
+			}
+			if len(seqs) != len(expect) || seqs[0] != expect[0] || seqs[len(seqs)-1] != expect[len(expect)-1] {
+				t.Fatalf("read %v, expect %v", seqs, expect)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package encode
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/gob"
 	"io"
 	"log"
@@ -57,3 +58,18 @@ func NewGobCodeProcess(connect io.ReadWriteCloser) CodeProcess {
 		buffer:  buffer,
 	}
 }
+
+type gobSerializer struct{}
+
+// Marshal :every value carries its own type descriptors
+func (gobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,73 @@
+package encode
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+type JsonCodeProcess struct {
+	connect io.ReadWriteCloser
+	encoder *json.Encoder
+	decoder *json.Decoder
+	buffer  *bufio.Writer
+}
+
+func (c *JsonCodeProcess) ReadHeader(h *Header) error {
+	return c.decoder.Decode(h)
+}
+
+// ReadBody :body must be a pointer. A nil body still consumes the next value
+// so that the stream stays aligned with the following header.
+func (c *JsonCodeProcess) ReadBody(body interface{}) error {
+	if body == nil {
+		var discard json.RawMessage
+		return c.decoder.Decode(&discard)
+	}
+	return c.decoder.Decode(body)
+}
+
+func (c *JsonCodeProcess) Close() error {
+	return c.connect.Close()
+}
+
+func (c *JsonCodeProcess) Write(header *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buffer.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err := c.encoder.Encode(header); err != nil {
+		log.Println("rpc encoding: json error encoding header:", err)
+		return err
+	}
+	if err := c.encoder.Encode(body); err != nil {
+		log.Println("rpc encoding: json error encoding body:", err)
+		return err
+	}
+	return nil
+}
+
+var _ CodeProcess = (*JsonCodeProcess)(nil)
+
+func NewJsonCodeProcess(connect io.ReadWriteCloser) CodeProcess {
+	buffer := bufio.NewWriter(connect)
+	return &JsonCodeProcess{
+		connect: connect,
+		encoder: json.NewEncoder(buffer),
+		decoder: json.NewDecoder(connect),
+		buffer:  buffer,
+	}
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,110 @@
+package encode
+
+import (
+	"bufio"
+	"io"
+	"log"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgMarshaler is implemented by types with generated msgpack code (e.g. tinylib/msgp).
+// MarshalMsg appends the encoding of the value to b.
+type MsgMarshaler interface {
+	MarshalMsg(b []byte) ([]byte, error)
+}
+
+// MsgUnmarshaler decodes one value from b and returns the remaining bytes
+type MsgUnmarshaler interface {
+	UnmarshalMsg(b []byte) ([]byte, error)
+}
+
+// MsgpackCodeProcess skips reflection for bodies implementing MsgMarshaler/MsgUnmarshaler,
+// other bodies fall back to the reflection based msgpack encoding
+type MsgpackCodeProcess struct {
+	connect io.ReadWriteCloser
+	encoder *msgpack.Encoder
+	decoder *msgpack.Decoder
+	buffer  *bufio.Writer
+	scratch []byte // reused by MarshalMsg,only touched while writing
+}
+
+func (c *MsgpackCodeProcess) ReadHeader(h *Header) error {
+	return c.decoder.Decode(h)
+}
+
+// ReadBody :body must be a pointer. A nil body skips the next value.
+func (c *MsgpackCodeProcess) ReadBody(body interface{}) error {
+	switch b := body.(type) {
+	case nil:
+		return c.decoder.Skip()
+	case MsgUnmarshaler:
+		raw, err := c.decoder.DecodeRaw()
+		if err != nil {
+			return err
+		}
+		_, err = b.UnmarshalMsg(raw)
+		return err
+	default:
+		return c.decoder.Decode(body)
+	}
+}
+
+func (c *MsgpackCodeProcess) Close() error {
+	return c.connect.Close()
+}
+
+func (c *MsgpackCodeProcess) Write(header *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buffer.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err := c.encoder.Encode(header); err != nil {
+		log.Println("rpc encoding: msgpack error encoding header:", err)
+		return err
+	}
+	if m, ok := body.(MsgMarshaler); ok {
+		c.scratch, err = m.MarshalMsg(c.scratch[:0])
+		if err == nil {
+			_, err = c.buffer.Write(c.scratch)
+		}
+	} else {
+		err = c.encoder.Encode(body)
+	}
+	if err != nil {
+		log.Println("rpc encoding: msgpack error encoding body:", err)
+		return err
+	}
+	return nil
+}
+
+var _ CodeProcess = (*MsgpackCodeProcess)(nil)
+
+func NewMsgpackCodeProcess(connect io.ReadWriteCloser) CodeProcess {
+	buffer := bufio.NewWriter(connect)
+	return &MsgpackCodeProcess{
+		connect: connect,
+		encoder: msgpack.NewEncoder(buffer),
+		decoder: msgpack.NewDecoder(connect),
+		buffer:  buffer,
+	}
+}
+
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(MsgMarshaler); ok {
+		return m.MarshalMsg(nil)
+	}
+	return msgpack.Marshal(v)
+}
+
+func (msgpackSerializer) Unmarshal(data []byte, v interface{}) error {
+	if u, ok := v.(MsgUnmarshaler); ok {
+		_, err := u.UnmarshalMsg(data)
+		return err
+	}
+	return msgpack.Unmarshal(data, v)
+}
@@ -0,0 +1,282 @@
+package encode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Header fields on the wire,numbered like a .proto message so that
+// other languages can decode the header with a plain schema
+const (
+	headerSeqField           protowire.Number = 1
+	headerServiceMethodField protowire.Number = 2
+	headerErrorField         protowire.Number = 3
+	headerMetadataField      protowire.Number = 4 // map<string, string>
+	headerKindField          protowire.Number = 5
+	headerTimeoutField       protowire.Number = 6 // nanoseconds
+	headerCreditField        protowire.Number = 7
+)
+
+// fields of a map entry
+const (
+	mapKeyField   protowire.Number = 1
+	mapValueField protowire.Number = 2
+)
+
+// ProtobufCodeProcess writes every header and body as a varint length
+// followed by the protobuf encoding of the message
+type ProtobufCodeProcess struct {
+	connect io.ReadWriteCloser
+	reader  *bufio.Reader
+	buffer  *bufio.Writer
+}
+
+// MaxProtobufFrameLength caps the size of a header or body,
+// a peer announcing a larger one is not trusted with the memory
+const MaxProtobufFrameLength = 1 << 26
+
+var errProtobufFrameTooLarge = errors.New("rpc encoding: protobuf frame too large")
+
+func (c *ProtobufCodeProcess) readFrame() ([]byte, error) {
+	size, err := binary.ReadUvarint(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxProtobufFrameLength {
+		return nil, errProtobufFrameTooLarge
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (c *ProtobufCodeProcess) writeFrame(frame []byte) error {
+	if len(frame) > MaxProtobufFrameLength {
+		return errProtobufFrameTooLarge
+	}
+	if _, err := c.buffer.Write(protowire.AppendVarint(nil, uint64(len(frame)))); err != nil {
+		return err
+	}
+	_, err := c.buffer.Write(frame)
+	return err
+}
+
+func (c *ProtobufCodeProcess) ReadHeader(h *Header) error {
+	frame, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	return unmarshalProtoHeader(frame, h)
+}
+
+// ReadBody :body must implement proto.Message. A nil body skips the frame.
+func (c *ProtobufCodeProcess) ReadBody(body interface{}) error {
+	frame, err := c.readFrame()
+	if err != nil || body == nil {
+		return err
+	}
+	return unmarshalProtoBody(frame, body)
+}
+
+func (c *ProtobufCodeProcess) Close() error {
+	return c.connect.Close()
+}
+
+func (c *ProtobufCodeProcess) Write(header *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buffer.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err := c.writeFrame(marshalProtoHeader(header)); err != nil {
+		log.Println("rpc encoding: protobuf error encoding header:", err)
+		return err
+	}
+	frame, err := marshalProtoBody(body)
+	if err != nil {
+		log.Println("rpc encoding: protobuf error encoding body:", err)
+		return err
+	}
+	if err := c.writeFrame(frame); err != nil {
+		log.Println("rpc encoding: protobuf error encoding body:", err)
+		return err
+	}
+	return nil
+}
+
+// marshalProtoBody :[]byte is passed through as an already encoded body
+func marshalProtoBody(body interface{}) ([]byte, error) {
+	switch b := body.(type) {
+	// placeholder bodies of error responses
+	case nil, struct{}:
+		return nil, nil
+	case []byte:
+		return b, nil
+	case proto.Message:
+		return proto.Marshal(b)
+	default:
+		return nil, fmt.Errorf("rpc encoding: protobuf body %T does not implement proto.Message", body)
+	}
+}
+
+func unmarshalProtoBody(frame []byte, body interface{}) error {
+	switch b := body.(type) {
+	case *[]byte:
+		*b = frame
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(frame, b)
+	default:
+		return fmt.Errorf("rpc encoding: protobuf body %T does not implement proto.Message", body)
+	}
+}
+
+func marshalProtoHeader(h *Header) []byte {
+	var b []byte
+	if h.Seq != 0 {
+		b = protowire.AppendTag(b, headerSeqField, protowire.VarintType)
+		b = protowire.AppendVarint(b, h.Seq)
+	}
+	if h.ServiceMethod != "" {
+		b = protowire.AppendTag(b, headerServiceMethodField, protowire.BytesType)
+		b = protowire.AppendString(b, h.ServiceMethod)
+	}
+	if h.Error != "" {
+		b = protowire.AppendTag(b, headerErrorField, protowire.BytesType)
+		b = protowire.AppendString(b, h.Error)
+	}
+	if h.Kind != KindCall {
+		b = protowire.AppendTag(b, headerKindField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Kind))
+	}
+	if h.Timeout != 0 {
+		b = protowire.AppendTag(b, headerTimeoutField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Timeout))
+	}
+	if h.Credit != 0 {
+		b = protowire.AppendTag(b, headerCreditField, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Credit))
+	}
+	for key, value := range h.Metadata {
+		var entry []byte
+		entry = protowire.AppendTag(entry, mapKeyField, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, mapValueField, protowire.BytesType)
+		entry = protowire.AppendString(entry, value)
+		b = protowire.AppendTag(b, headerMetadataField, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+func unmarshalProtoMapEntry(b []byte) (key, value string, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == mapKeyField && typ == protowire.BytesType:
+			key, n = protowire.ConsumeString(b)
+		case num == mapValueField && typ == protowire.BytesType:
+			value, n = protowire.ConsumeString(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return key, value, nil
+}
+
+// unmarshalProtoHeader skips unknown fields so newer peers can extend the header
+func unmarshalProtoHeader(b []byte, h *Header) error {
+	*h = Header{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == headerSeqField && typ == protowire.VarintType:
+			h.Seq, n = protowire.ConsumeVarint(b)
+		case num == headerServiceMethodField && typ == protowire.BytesType:
+			h.ServiceMethod, n = protowire.ConsumeString(b)
+		case num == headerErrorField && typ == protowire.BytesType:
+			h.Error, n = protowire.ConsumeString(b)
+		case num == headerKindField && typ == protowire.VarintType:
+			var kind uint64
+			kind, n = protowire.ConsumeVarint(b)
+			h.Kind = Kind(kind)
+		case num == headerTimeoutField && typ == protowire.VarintType:
+			var timeout uint64
+			timeout, n = protowire.ConsumeVarint(b)
+			h.Timeout = time.Duration(timeout)
+		case num == headerCreditField && typ == protowire.VarintType:
+			var credit uint64
+			credit, n = protowire.ConsumeVarint(b)
+			h.Credit = uint32(credit)
+		case num == headerMetadataField && typ == protowire.BytesType:
+			var entry []byte
+			if entry, n = protowire.ConsumeBytes(b); n >= 0 {
+				key, value, err := unmarshalProtoMapEntry(entry)
+				if err != nil {
+					return err
+				}
+				if h.Metadata == nil {
+					h.Metadata = make(map[string]string)
+				}
+				h.Metadata[key] = value
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+var _ CodeProcess = (*ProtobufCodeProcess)(nil)
+
+func NewProtobufCodeProcess(connect io.ReadWriteCloser) CodeProcess {
+	return &ProtobufCodeProcess{
+		connect: connect,
+		reader:  bufio.NewReader(connect),
+		buffer:  bufio.NewWriter(connect),
+	}
+}
+
+type protobufSerializer struct{}
+
+// Marshal :headers use the same wire format as ProtobufCodeProcess
+func (protobufSerializer) Marshal(v interface{}) ([]byte, error) {
+	if h, ok := v.(*Header); ok {
+		return marshalProtoHeader(h), nil
+	}
+	return marshalProtoBody(v)
+}
+
+func (protobufSerializer) Unmarshal(data []byte, v interface{}) error {
+	if h, ok := v.(*Header); ok {
+		return unmarshalProtoHeader(data, h)
+	}
+	return unmarshalProtoBody(data, v)
+}
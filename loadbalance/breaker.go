@@ -0,0 +1,167 @@
+package loadbalance
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned for calls to a server whose circuit breaker is open
+var ErrBreakerOpen = errors.New("rpc loadbalance: circuit breaker open")
+
+// BreakerPolicy tells when the circuit breaker of a server trips
+type BreakerPolicy struct {
+	// ConsecutiveFailures trips the breaker after this many failed calls in a row,0 disables it
+	ConsecutiveFailures int
+	// ErrorRate trips the breaker once this fraction of the calls within Window failed,
+	// given at least MinRequests calls. 0 disables it.
+	ErrorRate   float64
+	MinRequests int
+	Window      time.Duration
+	// OpenTimeout is how long a tripped breaker skips the server,
+	// then one probe call is let through and closes the breaker again if it succeeds
+	OpenTimeout time.Duration
+}
+
+// DefaultBreakerPolicy trips on a server that keeps failing
+var DefaultBreakerPolicy = &BreakerPolicy{
+	ConsecutiveFailures: 5,
+	ErrorRate:           0.5,
+	MinRequests:         20,
+	Window:              10 * time.Second,
+	OpenTimeout:         5 * time.Second,
+}
+
+// failures are the classes of errors telling the server is unhealthy,
+// errors of the service method mean it still works
+const failures = RetryUnavailable | RetryBusy | RetryConnectionLost | RetryTimeout
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // calls go through
+	breakerOpen                         // calls are rejected until OpenTimeout passed
+	breakerHalfOpen                     // one probe call is in flight
+)
+
+// circuitBreaker of one server,a nil breaker lets every call through
+type circuitBreaker struct {
+	policy      *BreakerPolicy
+	mu          sync.Mutex // protect following
+	state       breakerState
+	failures    int       // in a row
+	windowStart time.Time // of the calls and errors counted for ErrorRate
+	calls       int
+	errors      int
+	openedAt    time.Time
+}
+
+// ready reports whether a call may go to the server,without changing the state
+func (b *circuitBreaker) ready() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		return time.Since(b.openedAt) >= b.policy.OpenTimeout
+	case breakerHalfOpen:
+		return false
+	}
+	return true
+}
+
+// acquire lets a call through,an open breaker past OpenTimeout lets it through as the probe
+func (b *circuitBreaker) acquire() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.policy.OpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+	case breakerHalfOpen:
+		return false
+	}
+	return true
+}
+
+// record takes the outcome of a call let through by acquire,
+// neutral calls,e.g. cancelled by the caller,tell nothing about the server
+func (b *circuitBreaker) record(failed, neutral bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		switch {
+		case neutral:
+			// let the next call probe
+			b.state, b.openedAt = breakerOpen, time.Time{}
+		case failed:
+			b.trip()
+		default:
+			b.reset()
+		}
+		return
+	}
+	if neutral || b.state != breakerClosed {
+		return
+	}
+	if time.Since(b.windowStart) >= b.policy.Window {
+		b.windowStart, b.calls, b.errors = time.Now(), 0, 0
+	}
+	b.calls++
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	b.errors++
+	if (b.policy.ConsecutiveFailures > 0 && b.failures >= b.policy.ConsecutiveFailures) ||
+		(b.policy.ErrorRate > 0 && b.calls >= b.policy.MinRequests &&
+			float64(b.errors) >= b.policy.ErrorRate*float64(b.calls)) {
+		b.trip()
+	}
+}
+
+func (b *circuitBreaker) trip() {
+	b.state, b.openedAt = breakerOpen, time.Now()
+}
+
+func (b *circuitBreaker) reset() {
+	b.state, b.failures = breakerClosed, 0
+	b.windowStart, b.calls, b.errors = time.Now(), 0, 0
+}
+
+// SetBreakerPolicy gives every server a circuit breaker,nil means none.
+// Call it before the client is used.
+func (bc *BalanceClient) SetBreakerPolicy(policy *BreakerPolicy) {
+	bc.breakerPolicy = policy
+}
+
+// breaker returns the circuit breaker of protocolAddr,nil without a BreakerPolicy
+func (bc *BalanceClient) breaker(protocolAddr string) *circuitBreaker {
+	if bc.breakerPolicy == nil {
+		return nil
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	b := bc.breakers[protocolAddr]
+	if b == nil {
+		b = &circuitBreaker{policy: bc.breakerPolicy, windowStart: time.Now()}
+		bc.breakers[protocolAddr] = b
+	}
+	return b
+}
+
+// available reports whether protocolAddr may be selected,it is used as the filter of Discover.Get
+func (bc *BalanceClient) available(protocolAddr string) bool {
+	return bc.breaker(protocolAddr).ready()
+}
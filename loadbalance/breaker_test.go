@@ -0,0 +1,138 @@
+package loadbalance
+
+import (
+	. "MicroRPC"
+	"MicroRPC/registry"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	b := &circuitBreaker{policy: &BreakerPolicy{ConsecutiveFailures: 3, OpenTimeout: 50 * time.Millisecond}, windowStart: time.Now()}
+	record := func(failed bool) {
+		t.Helper()
+		if !b.acquire() {
+			t.Fatalf("acquire failed in state %d", b.state)
+		}
+		b.record(failed, false)
+	}
+	record(true)
+	record(true)
+	// a success breaks the row
+	record(false)
+	record(true)
+	record(true)
+	if b.state != breakerClosed {
+		t.Fatalf("state = %d after 2 failures in a row, expect closed", b.state)
+	}
+	record(true)
+	if b.state != breakerOpen || b.ready() || b.acquire() {
+		t.Fatalf("state = %d after 3 failures in a row, expect open", b.state)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if !b.ready() || !b.acquire() || b.state != breakerHalfOpen {
+		t.Fatalf("state = %d after OpenTimeout, expect a probe to be let through", b.state)
+	}
+	// one probe at a time
+	if b.ready() || b.acquire() {
+		t.Fatal("second probe let through")
+	}
+	b.record(true, false)
+	if b.state != breakerOpen || b.acquire() {
+		t.Fatalf("state = %d after a failed probe, expect open", b.state)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	record(false)
+	if b.state != breakerClosed {
+		t.Fatalf("state = %d after a successful probe, expect closed", b.state)
+	}
+}
+
+func TestBreakerNeutralProbe(t *testing.T) {
+	b := &circuitBreaker{policy: &BreakerPolicy{ConsecutiveFailures: 1, OpenTimeout: time.Minute}, windowStart: time.Now()}
+	b.record(true, false)
+	b.openedAt = time.Now().Add(-time.Minute)
+	if !b.acquire() {
+		t.Fatal("probe not let through")
+	}
+	// the caller cancelled the probe,the next call probes at once
+	b.record(false, true)
+	if b.state != breakerOpen || !b.acquire() {
+		t.Fatalf("state = %d after a cancelled probe, expect the next call to probe", b.state)
+	}
+}
+
+func TestBreakerErrorRate(t *testing.T) {
+	b := &circuitBreaker{policy: &BreakerPolicy{ErrorRate: 0.5, MinRequests: 4, Window: time.Minute, OpenTimeout: time.Minute}, windowStart: time.Now()}
+	for i, failed := range []bool{false, true, false} {
+		b.record(failed, false)
+		if b.state != breakerClosed {
+			t.Fatalf("state = %d after %d calls, expect closed below MinRequests", b.state, i+1)
+		}
+	}
+	// neutral calls don't count
+	b.record(true, true)
+	if b.state != breakerClosed {
+		t.Fatalf("state = %d after a neutral call, expect closed", b.state)
+	}
+	b.record(true, false)
+	if b.state != breakerOpen {
+		t.Fatalf("state = %d at half of 4 calls failed, expect open", b.state)
+	}
+}
+
+func TestBreakerSkipsServer(t *testing.T) {
+	busy := func(ctx context.Context, info *RequestInfo, next Handler) error {
+		return ErrServerBusy
+	}
+	_, broken := startServer(t, busy)
+	healthy, addr := startServer(t)
+	d := NewDiscovery([]string{broken, addr})
+	d.index = 0
+	bc := NewBalanceClient(RoundRobinSelect, d, nil)
+	defer func() { _ = bc.Close() }()
+	bc.SetBreakerPolicy(&BreakerPolicy{ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+	if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, new(int)); err != ErrServerBusy {
+		t.Fatalf("Sum = %v, expect %v", err, ErrServerBusy)
+	}
+	for i := 0; i < 4; i++ {
+		if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, new(int)); err != nil {
+			t.Fatalf("Sum = %v with the breaker of the busy server open", err)
+		}
+	}
+	if healthy.calls.Load() != 4 {
+		t.Fatalf("calls of the healthy server = %d, expect 4", healthy.calls.Load())
+	}
+}
+
+func TestBreakerSpillsOverZone(t *testing.T) {
+	busy := func(ctx context.Context, info *RequestInfo, next Handler) error {
+		return ErrServerBusy
+	}
+	_, local := startServer(t, busy)
+	remote, addr := startServer(t)
+	d := NewDiscovery([]string{local, addr})
+	d.SetLocalities(map[string]registry.Locality{
+		local: {Region: "r1", Zone: "a"},
+		addr:  {Region: "r1", Zone: "b"},
+	})
+	bc := NewBalanceClient(RoundRobinSelect, d, nil)
+	defer func() { _ = bc.Close() }()
+	bc.SetBreakerPolicy(&BreakerPolicy{ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+	bc.SetLocalityPolicy(&LocalityPolicy{Locality: registry.Locality{Region: "r1", Zone: "a"}, MinHealthy: 0.5})
+	// the zone takes the calls until its server fails
+	if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, new(int)); err != ErrServerBusy {
+		t.Fatalf("Sum = %v, expect %v from the local server", err, ErrServerBusy)
+	}
+	for i := 0; i < 4; i++ {
+		if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, new(int)); err != nil {
+			t.Fatalf("Sum = %v with the breaker of the local server open", err)
+		}
+	}
+	if remote.calls.Load() != 4 {
+		t.Fatalf("calls of the other zone = %d, expect 4", remote.calls.Load())
+	}
+}
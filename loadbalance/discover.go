@@ -1,6 +1,7 @@
 package loadbalance
 
 import (
+	"MicroRPC/registry"
 	"errors"
 	"math"
 	"math/rand"
@@ -11,30 +12,149 @@ import (
 type ModeSelect int
 
 const (
-	RandomSelect     ModeSelect = iota // select randomly
-	RoundRobinSelect                   // select using Robbin algorithm
+	RandomSelect             ModeSelect = iota // select randomly
+	RoundRobinSelect                           // select using Robbin algorithm
+	WeightedRoundRobinSelect                   // smooth weighted round robin by the weights of the servers
+	LeastOutstandingSelect                     // the server with the fewest calls in flight,see WithLoad
+	P2CSelect                                  // the less loaded of two random servers,power of two choices
+	ConsistentHashSelect                       // the server of the routing key on a hash ring,see WithKey
+	PeakEWMASelect                             // the cheaper of two random servers by latency and errors,see WithCost
 )
 
 type Discover interface {
 	Refresh() error // refresh from remote registry center
 	Update(services []string) error
-	Get(mode ModeSelect) (string, error)
+	Get(mode ModeSelect, opts ...SelectOption) (string, error)
 	GetAll() ([]string, error)
 }
 
+// SelectOptions inform the selection of a server
+type SelectOptions struct {
+	// Filter skips the servers it returns false for,e.g. those with an open circuit breaker
+	Filter func(protocolAddr string) bool
+	// Load returns the calls in flight on a server,for LeastOutstandingSelect and P2CSelect
+	Load func(protocolAddr string) int
+	// Key is the routing key for ConsistentHashSelect,calls without one are spread randomly
+	Key string
+	// Cost estimates the time a call to a server would take,for PeakEWMASelect
+	Cost func(protocolAddr string) float64
+	// Locality,if set,narrows the selection to the servers near the client
+	Locality *LocalityPolicy
+	// Service,if set,only selects the servers hosting it,see Discovery.Hosts
+	Service string
+}
+
+// LocalityPolicy keeps calls within the zone of the client,then within its region.
+// A zone spills over to the region,and a region to all servers,
+// once less than MinHealthy of its servers pass the Filter.
+type LocalityPolicy struct {
+	Locality registry.Locality // of the client
+	// MinHealthy is the fraction of the servers of a zone or region that must be healthy
+	// for it to take all the calls,e.g. 0.5. 0 spills over only when none is healthy.
+	MinHealthy float64
+}
+
+func (policy *LocalityPolicy) sameZone(l registry.Locality) bool {
+	return policy.Locality.Zone != "" && l.Zone == policy.Locality.Zone && l.Region == policy.Locality.Region
+}
+
+func (policy *LocalityPolicy) sameRegion(l registry.Locality) bool {
+	return policy.Locality.Region != "" && l.Region == policy.Locality.Region
+}
+
+type SelectOption func(*SelectOptions)
+
+// WithFilter only selects servers filter returns true for
+func WithFilter(filter func(protocolAddr string) bool) SelectOption {
+	return func(options *SelectOptions) {
+		options.Filter = filter
+	}
+}
+
+// WithLoad tells the load of the servers
+func WithLoad(load func(protocolAddr string) int) SelectOption {
+	return func(options *SelectOptions) {
+		options.Load = load
+	}
+}
+
+// WithKey sets the routing key for ConsistentHashSelect,calls with the same key go to the same server
+func WithKey(key string) SelectOption {
+	return func(options *SelectOptions) {
+		options.Key = key
+	}
+}
+
+// WithCost tells the expected cost of calls to the servers
+func WithCost(cost func(protocolAddr string) float64) SelectOption {
+	return func(options *SelectOptions) {
+		options.Cost = cost
+	}
+}
+
+// WithLocality prefers the servers near the client by policy
+func WithLocality(policy *LocalityPolicy) SelectOption {
+	return func(options *SelectOptions) {
+		options.Locality = policy
+	}
+}
+
+// WithService only selects servers hosting service,the part of serviceMethod before the dot
+func WithService(service string) SelectOption {
+	return func(options *SelectOptions) {
+		options.Service = service
+	}
+}
+
+func newSelectOptions(opts []SelectOption) *SelectOptions {
+	options := new(SelectOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// filter returns the services passing options.Filter
+func (options *SelectOptions) filter(services []string) []string {
+	if options.Filter == nil {
+		return services
+	}
+	filtered := make([]string, 0, len(services))
+	for _, service := range services {
+		if options.Filter(service) {
+			filtered = append(filtered, service)
+		}
+	}
+	return filtered
+}
+
 // Discovery :without a registry center ,user provides the server addresses explicitly instead
 type Discovery struct {
 	seed     *rand.Rand // random seed
 	index    int        // record the selected position for robin algorithm
 	mu       sync.RWMutex
-	services []string // protocolAddr of every server
+	services []string       // protocolAddr of every server
+	weights  map[string]int // key:protocolAddr,missing means 1
+	current  map[string]int // current weights of smooth weighted round robin
+	ring     *hashRing
+	// key:protocolAddr,for LocalityPolicy
+	localities map[string]registry.Locality
+	// key:protocolAddr value:names of the services it hosts,missing hosts every service
+	hosted map[string]map[string]bool
 }
 
 func NewDiscovery(services []string) *Discovery {
 	d := &Discovery{
 		seed:     rand.New(rand.NewSource(time.Now().UnixNano())),
 		services: services,
+		weights:  make(map[string]int),
+		current:  make(map[string]int),
+		ring:     newHashRing(),
+		// servers without a locality are never near
+		localities: make(map[string]registry.Locality),
+		hosted:     make(map[string]map[string]bool),
 	}
+	d.ring.sync(services)
 	d.index = d.seed.Intn(math.MaxInt32 - 1)
 	return d
 }
@@ -49,25 +169,203 @@ func (d *Discovery) Refresh() error {
 func (d *Discovery) Update(services []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.services = services
+	d.setServices(services)
 	return nil
 }
 
+// setServices replaces the services and the selection state derived from them,
+// d.mu must be held
+func (d *Discovery) setServices(services []string) {
+	d.services = services
+	d.current = make(map[string]int)
+	d.ring.sync(services)
+}
+
+// SetWeights sets the weights of the servers for WeightedRoundRobinSelect,
+// e.g. bigger instances get a bigger weight
+func (d *Discovery) SetWeights(weights map[string]int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.weights = weights
+	d.current = make(map[string]int)
+}
+
+// SetLocalities sets where the servers run for LocalityPolicy
+func (d *Discovery) SetLocalities(localities map[string]registry.Locality) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.localities = localities
+}
+
+// SetServiceNames sets the names of the services every server hosts,
+// servers missing in hosted are taken to host every service
+func (d *Discovery) SetServiceNames(hosted map[string][]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setServiceNames(hosted)
+}
+
+// setServiceNames is SetServiceNames with d.mu held
+func (d *Discovery) setServiceNames(hosted map[string][]string) {
+	d.hosted = make(map[string]map[string]bool, len(hosted))
+	for protocolAddr, names := range hosted {
+		d.hosted[protocolAddr] = make(map[string]bool, len(names))
+		for _, name := range names {
+			d.hosted[protocolAddr][name] = true
+		}
+	}
+}
+
+// Hosts reports whether protocolAddr hosts service
+func (d *Discovery) Hosts(protocolAddr, service string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.hosts(protocolAddr, service)
+}
+
+func (d *Discovery) hosts(protocolAddr, service string) bool {
+	names, ok := d.hosted[protocolAddr]
+	return !ok || names[service]
+}
+
+// localize returns the servers hosting options.Service and passing options.Filter,
+// only those of the nearest zone or region that is healthy enough with options.Locality
+func (d *Discovery) localize(options *SelectOptions) []string {
+	candidates := d.services
+	if options.Service != "" {
+		candidates = make([]string, 0, len(d.services))
+		for _, service := range d.services {
+			if d.hosts(service, options.Service) {
+				candidates = append(candidates, service)
+			}
+		}
+	}
+	healthy := options.filter(candidates)
+	policy := options.Locality
+	if policy == nil {
+		return healthy
+	}
+	for _, near := range []func(registry.Locality) bool{policy.sameZone, policy.sameRegion} {
+		total := 0
+		for _, service := range candidates {
+			if near(d.localities[service]) {
+				total++
+			}
+		}
+		var local []string
+		for _, service := range healthy {
+			if near(d.localities[service]) {
+				local = append(local, service)
+			}
+		}
+		if len(local) > 0 && float64(len(local)) >= policy.MinHealthy*float64(total) {
+			return local
+		}
+	}
+	return healthy
+}
+
+func (d *Discovery) weight(service string) int {
+	if weight := d.weights[service]; weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// smoothWeighted :every server gains its weight,the one ahead is selected and falls back by the total,
+// so a server of weight 3 next to one of weight 1 is selected a,a,b,a instead of a,a,a,b
+func (d *Discovery) smoothWeighted(services []string) string {
+	total, best := 0, ""
+	for _, service := range services {
+		weight := d.weight(service)
+		d.current[service] += weight
+		total += weight
+		if best == "" || d.current[service] > d.current[best] {
+			best = service
+		}
+	}
+	d.current[best] -= total
+	return best
+}
+
+// leastLoaded returns the server with the lowest load,ties broken from a random start
+func (d *Discovery) leastLoaded(services []string, load func(string) int) string {
+	n := len(services)
+	start := d.seed.Intn(n)
+	best, bestLoad := "", 0
+	for i := 0; i < n; i++ {
+		service := services[(start+i)%n]
+		if l := load(service); best == "" || l < bestLoad {
+			best, bestLoad = service, l
+		}
+	}
+	return best
+}
+
+// pickTwo returns two different random servers,len(services) must be at least 2
+func (d *Discovery) pickTwo(services []string) (string, string) {
+	n := len(services)
+	i := d.seed.Intn(n)
+	j := d.seed.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+	return services[i], services[j]
+}
+
 // Get a server according to mode
-func (d *Discovery) Get(mode ModeSelect) (string, error) {
+func (d *Discovery) Get(mode ModeSelect, opts ...SelectOption) (string, error) {
+	options := newSelectOptions(opts)
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	n := len(d.services)
+	services := d.localize(options)
+	n := len(services)
 	if n == 0 {
 		return "", errors.New("rpc discovery: no available services")
 	}
+	// the ring skips the servers left out itself,so only their keys move to the next one
+	if mode == ConsistentHashSelect && options.Key != "" {
+		selectable := make(map[string]bool, n)
+		for _, service := range services {
+			selectable[service] = true
+		}
+		return d.ring.get(options.Key, func(service string) bool { return selectable[service] }), nil
+	}
 	switch mode {
 	case RandomSelect:
-		return d.services[d.seed.Intn(n)], nil
+		return services[d.seed.Intn(n)], nil
 	case RoundRobinSelect:
-		s := d.services[d.index%n]
+		s := services[d.index%n]
 		d.index = (d.index + 1) % n
 		return s, nil
+	case WeightedRoundRobinSelect:
+		return d.smoothWeighted(services), nil
+	case LeastOutstandingSelect:
+		if options.Load == nil {
+			return services[d.seed.Intn(n)], nil
+		}
+		return d.leastLoaded(services, options.Load), nil
+	case P2CSelect:
+		if n == 1 || options.Load == nil {
+			return services[d.seed.Intn(n)], nil
+		}
+		a, b := d.pickTwo(services)
+		if options.Load(b) < options.Load(a) {
+			return b, nil
+		}
+		return a, nil
+	case PeakEWMASelect:
+		if n == 1 || options.Cost == nil {
+			return services[d.seed.Intn(n)], nil
+		}
+		a, b := d.pickTwo(services)
+		if options.Cost(b) < options.Cost(a) {
+			return b, nil
+		}
+		return a, nil
+	case ConsistentHashSelect:
+		// no routing key
+		return services[d.seed.Intn(n)], nil
 	default:
 		return "", errors.New("rpc discovery: not supported any select mode")
 	}
@@ -0,0 +1,121 @@
+package loadbalance
+
+import (
+	"MicroRPC/registry"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// selectN returns the servers selected by n calls of Get
+func selectN(t *testing.T, d Discover, mode ModeSelect, n int, opts ...SelectOption) []string {
+	t.Helper()
+	var selected []string
+	for i := 0; i < n; i++ {
+		service, err := d.Get(mode, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected = append(selected, service)
+	}
+	return selected
+}
+
+func TestSmoothWeightedRoundRobin(t *testing.T) {
+	d := NewDiscovery([]string{"a", "b"})
+	d.SetWeights(map[string]int{"a": 3})
+	// spread out instead of a,a,a,b
+	if got := strings.Join(selectN(t, d, WeightedRoundRobinSelect, 8), ","); got != "a,a,b,a,a,a,b,a" {
+		t.Fatalf("selected %s, expect a,a,b,a twice", got)
+	}
+}
+
+func TestLoadAwareSelect(t *testing.T) {
+	d := NewDiscovery([]string{"a", "b", "c"})
+	loads := map[string]int{"a": 5, "b": 1, "c": 3}
+	load := WithLoad(func(service string) int { return loads[service] })
+	for _, service := range selectN(t, d, LeastOutstandingSelect, 10, load) {
+		if service != "b" {
+			t.Fatalf("least outstanding selected %s, expect b", service)
+		}
+	}
+	// two random servers,the less loaded wins: a is never selected,b always when drawn
+	counts := make(map[string]int)
+	for _, service := range selectN(t, d, P2CSelect, 300, load) {
+		counts[service]++
+	}
+	if counts["a"] != 0 || counts["b"] < counts["c"] {
+		t.Fatalf("p2c selected %v, expect no a and b at least as often as c", counts)
+	}
+}
+
+func TestSelectFilter(t *testing.T) {
+	d := NewDiscovery([]string{"a", "b", "c"})
+	notB := WithFilter(func(service string) bool { return service != "b" })
+	for _, mode := range []ModeSelect{RandomSelect, RoundRobinSelect, WeightedRoundRobinSelect, P2CSelect} {
+		for _, service := range selectN(t, d, mode, 10, notB) {
+			if service == "b" {
+				t.Fatalf("mode %d selected the filtered server", mode)
+			}
+		}
+	}
+}
+
+func TestLocalitySpillover(t *testing.T) {
+	d := NewDiscovery([]string{"a1", "a2", "b1", "b2", "c1"})
+	d.SetLocalities(map[string]registry.Locality{
+		"a1": {Region: "r1", Zone: "a"},
+		"a2": {Region: "r1", Zone: "a"},
+		"b1": {Region: "r1", Zone: "b"},
+		"b2": {Region: "r1", Zone: "b"},
+		"c1": {Region: "r2", Zone: "c"},
+	})
+	policy := &LocalityPolicy{Locality: registry.Locality{Region: "r1", Zone: "a"}}
+	// selected returns the servers selected while down are unhealthy
+	selected := func(down ...string) string {
+		filter := WithFilter(func(service string) bool { return !slices.Contains(down, service) })
+		var services []string
+		for _, service := range selectN(t, d, RoundRobinSelect, 10, WithLocality(policy), filter) {
+			if !slices.Contains(services, service) {
+				services = append(services, service)
+			}
+		}
+		slices.Sort(services)
+		return strings.Join(services, ",")
+	}
+	for _, tc := range []struct {
+		minHealthy float64
+		down       []string
+		expect     string
+	}{
+		{0.5, nil, "a1,a2"},
+		{0.5, []string{"a1"}, "a2"},                 // half of the zone is enough
+		{0.5, []string{"a1", "a2"}, "b1,b2"},        // the zone spills over to the region
+		{0.5, []string{"a1", "a2", "b1"}, "b2,c1"},  // the region spills over to all servers
+		{1, []string{"a1"}, "a2,b1,b2,c1"},          // every server of the zone and region is required
+		{0, []string{"a1", "a2", "b1", "b2"}, "c1"}, // 0 spills over only when none is healthy
+		{0, []string{"a1"}, "a2"},
+	} {
+		policy.MinHealthy = tc.minHealthy
+		if got := selected(tc.down...); got != tc.expect {
+			t.Fatalf("MinHealthy %v with %v down selected %s, expect %s", tc.minHealthy, tc.down, got, tc.expect)
+		}
+	}
+}
+
+func TestSelectService(t *testing.T) {
+	d := NewDiscovery([]string{"a", "b", "c"})
+	// c doesn't tell its services,it is taken to host all of them
+	d.SetServiceNames(map[string][]string{"a": {"Arith"}, "b": {"Sleeper"}})
+	for _, service := range selectN(t, d, RoundRobinSelect, 10, WithService("Arith")) {
+		if service == "b" {
+			t.Fatal("selected b, which doesn't host Arith")
+		}
+	}
+	if _, err := d.Get(RoundRobinSelect, WithService("Arith"), WithFilter(func(service string) bool { return service == "b" })); err == nil {
+		t.Fatal("selected a server without Arith")
+	}
+	if !d.Hosts("a", "Arith") || d.Hosts("a", "Sleeper") || !d.Hosts("c", "Sleeper") {
+		t.Fatal("Hosts doesn't match the service names")
+	}
+}
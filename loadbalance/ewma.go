@@ -0,0 +1,90 @@
+package loadbalance
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultLatencyDecay is the time after which a measurement keeps about a third of its weight
+const defaultLatencyDecay = 10 * time.Second
+
+// maxErrorRate keeps the cost of a failing server finite,so it is still tried now and then
+const maxErrorRate = 0.99
+
+// peakEWMA tracks the latency and error rate of one server for PeakEWMASelect,
+// both are moving averages weighted by the time between calls
+type peakEWMA struct {
+	decay     time.Duration
+	mu        sync.Mutex // protect following
+	latency   float64    // in nanoseconds
+	errorRate float64    // 0 to 1
+	stamp     time.Time  // of the last call observed
+}
+
+// weight returns the weight the values observed at stamp still have at now
+func (e *peakEWMA) weight(now time.Time) float64 {
+	if e.stamp.IsZero() {
+		return 0
+	}
+	return math.Exp(-float64(now.Sub(e.stamp)) / float64(e.decay))
+}
+
+// observe takes the latency of a call and whether the server failed it.
+// A latency above the average replaces it at once,so a server turning slow is avoided
+// after one call,while a server turning fast again is trusted gradually.
+func (e *peakEWMA) observe(latency time.Duration, failed bool) {
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w := e.weight(now)
+	if rtt := float64(latency); rtt > e.latency {
+		e.latency = rtt
+	} else {
+		e.latency = e.latency*w + rtt*(1-w)
+	}
+	var f float64
+	if failed {
+		f = 1
+	}
+	e.errorRate = e.errorRate*w + f*(1-w)
+	e.stamp = now
+}
+
+// cost estimates the time a call takes with pending calls in flight before it,
+// a call that may fail counts as often as it takes to succeed.
+// Measurements decay towards 0 while the server isn't called,so it is tried again after a while.
+func (e *peakEWMA) cost(pending int) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w := e.weight(time.Now())
+	errorRate := math.Min(e.errorRate*w, maxErrorRate)
+	return e.latency * w * float64(pending+1) / (1 - errorRate)
+}
+
+// SetLatencyDecay sets how fast old latencies and errors are forgotten by PeakEWMASelect,
+// a shorter decay follows changes faster but is noisier,<= 0 means the default of 10s.
+// Call it before the client is used.
+func (bc *BalanceClient) SetLatencyDecay(decay time.Duration) {
+	if decay <= 0 {
+		decay = defaultLatencyDecay
+	}
+	bc.latencyDecay = decay
+}
+
+// latencyStats returns the latency and error rate of protocolAddr
+func (bc *BalanceClient) latencyStats(protocolAddr string) *peakEWMA {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	e := bc.stats[protocolAddr]
+	if e == nil {
+		e = &peakEWMA{decay: bc.latencyDecay}
+		bc.stats[protocolAddr] = e
+	}
+	return e
+}
+
+// cost is the Cost of Discover.Get,servers never called cost 0 so they are tried first
+func (bc *BalanceClient) cost(protocolAddr string) float64 {
+	return bc.latencyStats(protocolAddr).cost(bc.load(protocolAddr))
+}
@@ -0,0 +1,75 @@
+package loadbalance
+
+import (
+	. "MicroRPC"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPeakEWMA(t *testing.T) {
+	e := &peakEWMA{decay: time.Second}
+	if cost := e.cost(0); cost != 0 {
+		t.Fatalf("cost of a server never called = %v, expect 0", cost)
+	}
+	e.observe(10*time.Millisecond, false)
+	// a slower call replaces the average at once
+	e.observe(100*time.Millisecond, false)
+	if e.latency != float64(100*time.Millisecond) {
+		t.Fatalf("latency after the peak = %v, expect 100ms", time.Duration(e.latency))
+	}
+	// faster calls bring it down gradually
+	e.observe(10*time.Millisecond, false)
+	if latency := time.Duration(e.latency); latency <= 10*time.Millisecond || latency >= 100*time.Millisecond {
+		t.Fatalf("latency after a fast call = %v, expect between 10ms and 100ms", latency)
+	}
+	// the cost grows with the calls in flight and with errors
+	if e.cost(1) <= e.cost(0) {
+		t.Fatalf("cost with a call in flight %v, expect more than %v", e.cost(1), e.cost(0))
+	}
+	before := e.cost(0)
+	e.stamp = e.stamp.Add(-time.Second)
+	e.observe(time.Duration(e.latency), true)
+	if e.errorRate <= 0 || e.cost(0) <= before {
+		t.Fatalf("error rate %v, cost %v after a failure, expect more than %v", e.errorRate, e.cost(0), before)
+	}
+	// unused,the cost decays towards 0
+	e.stamp = e.stamp.Add(-10 * time.Second)
+	if cost := e.cost(0); cost >= before/100 {
+		t.Fatalf("cost after 10 decays = %v, expect about 0", cost)
+	}
+}
+
+func TestPeakEWMASelect(t *testing.T) {
+	d := NewDiscovery([]string{"a", "b", "c"})
+	costs := map[string]float64{"a": 5, "b": 1, "c": 3}
+	cost := WithCost(func(service string) float64 { return costs[service] })
+	// two random servers,the cheaper wins: a is never selected,b always when drawn
+	counts := make(map[string]int)
+	for _, service := range selectN(t, d, PeakEWMASelect, 300, cost) {
+		counts[service]++
+	}
+	if counts["a"] != 0 || counts["b"] < counts["c"] {
+		t.Fatalf("peak ewma selected %v, expect no a and b at least as often as c", counts)
+	}
+}
+
+func TestPeakEWMAPrefersFaster(t *testing.T) {
+	delay := func(ctx context.Context, info *RequestInfo, next Handler) error {
+		time.Sleep(20 * time.Millisecond)
+		return next(ctx, info)
+	}
+	slow, slowAddr := startServer(t, delay)
+	fast, fastAddr := startServer(t)
+	bc := newBalanceClient(t, PeakEWMASelect, slowAddr, fastAddr)
+	for i := 0; i < 20; i++ {
+		var reply int
+		if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: i, Num2: 1}, &reply); err != nil || reply != i+1 {
+			t.Fatalf("Sum = %d, %v", reply, err)
+		}
+	}
+	// each server is tried while it costs 0,then the fast one wins every pair
+	if slow.calls.Load() > 2 || fast.calls.Load() < 18 {
+		t.Fatalf("calls = %d slow, %d fast, expect the fast server after the first calls", slow.calls.Load(), fast.calls.Load())
+	}
+}
@@ -0,0 +1,108 @@
+package loadbalance
+
+import (
+	"context"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// hashRingReplicas is the number of virtual nodes of every server on the ring,
+// more of them spread the keys more evenly
+const hashRingReplicas = 100
+
+// hashRing maps keys to servers for ConsistentHashSelect,
+// a server joining or leaving only moves the keys next to its virtual nodes
+type hashRing struct {
+	hashes  []uint32          // sorted hashes of all virtual nodes
+	owners  map[uint32]string // key:hash of a virtual node value:protocolAddr
+	members map[string]bool
+}
+
+func newHashRing() *hashRing {
+	return &hashRing{owners: make(map[uint32]string), members: make(map[string]bool)}
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// sync updates the ring to services,only the virtual nodes of servers that joined or left change
+func (r *hashRing) sync(services []string) {
+	current := make(map[string]bool, len(services))
+	for _, service := range services {
+		current[service] = true
+	}
+	changed := false
+	for service := range r.members {
+		if !current[service] {
+			for i := 0; i < hashRingReplicas; i++ {
+				// a colliding virtual node may belong to another server
+				if hash := hashKey(strconv.Itoa(i) + service); r.owners[hash] == service {
+					delete(r.owners, hash)
+				}
+			}
+			delete(r.members, service)
+			changed = true
+		}
+	}
+	for service := range current {
+		if !r.members[service] {
+			for i := 0; i < hashRingReplicas; i++ {
+				r.owners[hashKey(strconv.Itoa(i)+service)] = service
+			}
+			r.members[service] = true
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	r.hashes = r.hashes[:0]
+	for hash := range r.owners {
+		r.hashes = append(r.hashes, hash)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// get returns the server of key,the next one clockwise if filter skips it.
+// It returns "" when no server passes filter.
+func (r *hashRing) get(key string, filter func(string) bool) string {
+	n := len(r.hashes)
+	if n == 0 {
+		return ""
+	}
+	hash := hashKey(key)
+	start := sort.Search(n, func(i int) bool { return r.hashes[i] >= hash })
+	for i := 0; i < n; i++ {
+		service := r.owners[r.hashes[(start+i)%n]]
+		if filter == nil || filter(service) {
+			return service
+		}
+	}
+	return ""
+}
+
+// RoutingKeyer is implemented by arguments carrying their routing key for ConsistentHashSelect
+type RoutingKeyer interface {
+	RoutingKey() string
+}
+
+type routingKey struct{}
+
+// WithRoutingKey sets the routing key of calls made with ctx for ConsistentHashSelect,
+// it takes precedence over RoutingKeyer arguments
+func WithRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKey{}, key)
+}
+
+// routingKeyOf returns the routing key from ctx or args,"" without one
+func routingKeyOf(ctx context.Context, args interface{}) string {
+	if key, ok := ctx.Value(routingKey{}).(string); ok {
+		return key
+	}
+	if keyer, ok := args.(RoutingKeyer); ok {
+		return keyer.RoutingKey()
+	}
+	return ""
+}
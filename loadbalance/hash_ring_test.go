@@ -0,0 +1,85 @@
+package loadbalance
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+// owners returns the server of every key
+func owners(t *testing.T, d *Discovery, keys []string, opts ...SelectOption) map[string]string {
+	t.Helper()
+	owners := make(map[string]string)
+	for _, key := range keys {
+		service, err := d.Get(ConsistentHashSelect, append(opts, WithKey(key))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owners[key] = service
+	}
+	return owners
+}
+
+func TestHashRingStability(t *testing.T) {
+	var keys []string
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, "user-"+strconv.Itoa(i))
+	}
+	d := NewDiscovery([]string{"a", "b", "c"})
+	before := owners(t, d, keys)
+	for key, service := range owners(t, d, keys) {
+		if service != before[key] {
+			t.Fatalf("key %s moved from %s to %s without a change", key, before[key], service)
+		}
+	}
+
+	// a joining server only takes keys,about a quarter of them
+	_ = d.Update([]string{"a", "b", "c", "d"})
+	moved := 0
+	for key, service := range owners(t, d, keys) {
+		if service != before[key] {
+			if service != "d" {
+				t.Fatalf("key %s moved from %s to %s, expect only moves to d", key, before[key], service)
+			}
+			moved++
+		}
+	}
+	if moved < len(keys)/8 || moved > len(keys)/2 {
+		t.Fatalf("%d of %d keys moved to d", moved, len(keys))
+	}
+
+	// and gives them back when it leaves
+	_ = d.Update([]string{"a", "b", "c"})
+	for key, service := range owners(t, d, keys) {
+		if service != before[key] {
+			t.Fatalf("key %s is on %s after d left, expect %s", key, service, before[key])
+		}
+	}
+
+	// only the keys of a filtered server move
+	notB := WithFilter(func(service string) bool { return service != "b" })
+	for key, service := range owners(t, d, keys, notB) {
+		if service == "b" || (before[key] != "b" && service != before[key]) {
+			t.Fatalf("key %s is on %s with b filtered, was on %s", key, service, before[key])
+		}
+	}
+}
+
+type keyedArgs struct{ User string }
+
+func (a keyedArgs) RoutingKey() string {
+	return a.User
+}
+
+func TestRoutingKeyOf(t *testing.T) {
+	ctx := context.Background()
+	if key := routingKeyOf(ctx, keyedArgs{User: "u1"}); key != "u1" {
+		t.Fatalf("key of RoutingKeyer args = %q, expect u1", key)
+	}
+	if key := routingKeyOf(WithRoutingKey(ctx, "u2"), keyedArgs{User: "u1"}); key != "u2" {
+		t.Fatalf("key = %q, expect the one of ctx", key)
+	}
+	if key := routingKeyOf(ctx, 1); key != "" {
+		t.Fatalf("key = %q, expect none", key)
+	}
+}
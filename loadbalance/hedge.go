@@ -0,0 +1,163 @@
+package loadbalance
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgePolicy sends further copies of a slow call to other servers,
+// only set it for methods that are safe to run more than once
+type HedgePolicy struct {
+	MaxAttempts int // copies in total including the first one
+	// Delay is the wait for a response before the next copy is sent
+	Delay time.Duration
+	// Percentile,if set,derives the delay from the latencies of recent calls instead,
+	// e.g. 0.95 sends a copy once the call is slower than 95% of them. Delay is used until
+	// enough calls were measured.
+	Percentile float64
+}
+
+const (
+	latencySamples    = 128 // latencies kept per method
+	minLatencySamples = 16  // latencies needed for a percentile
+)
+
+// latencyWindow keeps the latencies of the last calls of a method
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int // position of the next sample once samples is full
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, latency)
+		return
+	}
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % latencySamples
+}
+
+// percentile returns the p-th percentile,false without enough samples
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	samples := append([]time.Duration(nil), w.samples...)
+	w.mu.Unlock()
+	if len(samples) < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(p * float64(len(samples)))
+	if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	return samples[i], true
+}
+
+// SetHedgePolicy hedges calls of serviceMethod,nil stops hedging it.
+// Hedged calls are not retried. Call it before the client is used.
+func (bc *BalanceClient) SetHedgePolicy(serviceMethod string, policy *HedgePolicy) {
+	if bc.hedgePolicies == nil {
+		bc.hedgePolicies = make(map[string]*HedgePolicy)
+		bc.latencies = make(map[string]*latencyWindow)
+	}
+	bc.hedgePolicies[serviceMethod] = policy
+	bc.latencies[serviceMethod] = new(latencyWindow)
+}
+
+// hedgeDelay returns the wait before the next copy of serviceMethod
+func (bc *BalanceClient) hedgeDelay(serviceMethod string, policy *HedgePolicy) time.Duration {
+	if policy.Percentile > 0 {
+		if delay, ok := bc.latencies[serviceMethod].percentile(policy.Percentile); ok {
+			return delay
+		}
+	}
+	return policy.Delay
+}
+
+// newReply returns a new reply of the type reply points to,nil for a nil reply
+func newReply(reply interface{}) interface{} {
+	if reply == nil {
+		return nil
+	}
+	return reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+}
+
+// setReply copies the reply of a successful copy of a call into reply
+func setReply(reply, clonedReply interface{}) {
+	if reply != nil {
+		reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
+	}
+}
+
+// hedge sends a copy of the call to another server each time the delay passes without
+// a response,or at once when a copy fails. The first success wins and the others are cancelled.
+func (bc *BalanceClient) hedge(ctx context.Context, serviceMethod string, args, reply interface{}, policy *HedgePolicy) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	results := make(chan result, policy.MaxAttempts)
+	tried := make(map[string]ErrorClass)
+	// send starts one more copy,false once there is no server left to try
+	send := func() (bool, error) {
+		protocolAddr, err := bc.selectUntried(ctx, serviceMethod, args, tried)
+		if err != nil {
+			return false, err
+		}
+		if _, ok := tried[protocolAddr]; ok {
+			return false, nil
+		}
+		tried[protocolAddr] = 0
+		clonedReply := newReply(reply)
+		go func() {
+			start := time.Now()
+			err := bc.call(protocolAddr, ctx, serviceMethod, args, clonedReply)
+			if err == nil {
+				bc.latencies[serviceMethod].add(time.Since(start))
+			}
+			results <- result{reply: clonedReply, err: err}
+		}()
+		return true, nil
+	}
+
+	sent, err := send()
+	if !sent {
+		return err
+	}
+	pending, attempts := 1, 1
+	timer := time.NewTimer(bc.hedgeDelay(serviceMethod, policy))
+	defer timer.Stop()
+	for {
+		hedge := false
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				setReply(reply, r.reply)
+				return nil
+			}
+			err = r.err
+			hedge = true
+		case <-timer.C:
+			hedge = true
+		}
+		if hedge && attempts < policy.MaxAttempts && ctx.Err() == nil {
+			if ok, _ := send(); ok {
+				pending++
+				attempts++
+				timer.Reset(bc.hedgeDelay(serviceMethod, policy))
+			}
+		}
+		if pending == 0 {
+			return err
+		}
+	}
+}
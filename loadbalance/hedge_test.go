@@ -0,0 +1,99 @@
+package loadbalance
+
+import (
+	. "MicroRPC"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// stuck never lets a call reach the method,until the call is cancelled
+func stuck(ctx context.Context, info *RequestInfo, next Handler) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// newHedgeClient hedges Arith.Sum over servers,the first copy always goes to the first server
+func newHedgeClient(t *testing.T, policy *HedgePolicy, servers ...string) *BalanceClient {
+	t.Helper()
+	d := NewDiscovery(servers)
+	d.index = 0
+	bc := NewBalanceClient(RoundRobinSelect, d, nil)
+	t.Cleanup(func() { _ = bc.Close() })
+	bc.SetHedgePolicy("Arith.Sum", policy)
+	return bc
+}
+
+func TestHedgeAfterDelay(t *testing.T) {
+	_, slow := startServer(t, stuck)
+	healthy, addr := startServer(t)
+	bc := newHedgeClient(t, &HedgePolicy{MaxAttempts: 2, Delay: 50 * time.Millisecond}, slow, addr)
+	start := time.Now()
+	var reply int
+	if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("Sum = %d, %v", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("hedged call took %s, expect about the 50ms delay", elapsed)
+	}
+	if healthy.calls.Load() != 1 {
+		t.Fatalf("calls of the healthy server = %d, expect 1", healthy.calls.Load())
+	}
+}
+
+func TestHedgeOnFailure(t *testing.T) {
+	failing := func(ctx context.Context, info *RequestInfo, next Handler) error {
+		return errors.New("fail")
+	}
+	_, slow := startServer(t, stuck)
+	_, broken := startServer(t, failing)
+	_, addr := startServer(t)
+	bc := newHedgeClient(t, &HedgePolicy{MaxAttempts: 3, Delay: 300 * time.Millisecond}, slow, broken, addr)
+	start := time.Now()
+	var reply int
+	if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("Sum = %d, %v", reply, err)
+	}
+	// the second copy fails at once,the third one doesn't wait for another delay
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("hedged call took %s, expect the third copy right after the second failed", elapsed)
+	}
+}
+
+func TestDialDoesNotBlockOtherServers(t *testing.T) {
+	// accepts connections but never answers the option,dialing it takes a while
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// held open until the listener closes
+			defer func() { _ = conn.Close() }()
+		}
+	}()
+	_, addr := startServer(t)
+	bc := newBalanceClient(t, RandomSelect, addr)
+	dialed := make(chan struct{})
+	go func() {
+		_, _ = bc.dial("tcp@" + l.Addr().String())
+		close(dialed)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	var reply int
+	if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("Sum = %d, %v", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("call took %s while another server was dialed", elapsed)
+	}
+	<-dialed
+}
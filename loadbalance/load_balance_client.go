@@ -4,20 +4,55 @@ import (
 	. "MicroRPC"
 	"context"
 	"io"
-	"reflect"
+	"strings"
 	"sync"
+	"time"
 )
 
 type BalanceClient struct {
 	mode     ModeSelect
 	discover Discover
 	option   *Option
-	clients  map[string]*Client // key:protocolAddr value:client For reusing the connections
+	clients  map[string]*Client      // key:protocolAddr value:client For reusing the connections
+	dials    map[string]*pendingDial // key:protocolAddr,dials in progress
 	mu       sync.Mutex
+	// interceptors run around Call,including the selection of a server and retries
+	interceptors []ClientInterceptor
+	// retries,see SetRetryPolicy
+	retryPolicy         *RetryPolicy
+	methodRetryPolicies map[string]*RetryPolicy
+	idempotent          map[string]bool
+	// hedging,see SetHedgePolicy
+	hedgePolicies map[string]*HedgePolicy
+	latencies     map[string]*latencyWindow // of hedged methods
+	// circuit breakers,see SetBreakerPolicy
+	breakerPolicy *BreakerPolicy
+	breakers      map[string]*circuitBreaker // key:protocolAddr,protected by mu
+	// latency and errors of the servers for PeakEWMASelect,see SetLatencyDecay
+	latencyDecay time.Duration
+	stats        map[string]*peakEWMA // key:protocolAddr,protected by mu
+	// see SetLocalityPolicy
+	localityPolicy *LocalityPolicy
+}
+
+// pendingDial is a dial in progress,calls to the same server wait for it instead of dialing again
+type pendingDial struct {
+	done   chan struct{} // closed once client and err are set
+	client *Client
+	err    error
 }
 
 func NewBalanceClient(mode ModeSelect, discover Discover, option *Option) *BalanceClient {
-	return &BalanceClient{mode: mode, discover: discover, option: option, clients: make(map[string]*Client)}
+	return &BalanceClient{
+		mode:         mode,
+		discover:     discover,
+		option:       option,
+		clients:      make(map[string]*Client),
+		dials:        make(map[string]*pendingDial),
+		breakers:     make(map[string]*circuitBreaker),
+		latencyDecay: defaultLatencyDecay,
+		stats:        make(map[string]*peakEWMA),
+	}
 }
 
 func (bc *BalanceClient) Close() error {
@@ -32,41 +67,158 @@ func (bc *BalanceClient) Close() error {
 
 var _ io.Closer = (*BalanceClient)(nil)
 
-// add check if client can be reused and available
+// add check if client can be reused and available.
+// The dial runs without bc.mu,so a slow server doesn't hold up calls to the others.
 func (bc *BalanceClient) dial(protocolAddr string) (*Client, error) {
 	bc.mu.Lock()
-	defer bc.mu.Unlock()
 	client, ok := bc.clients[protocolAddr]
-	if ok && !client.IsAvailable() {
-		_ = client.Close()
-		delete(bc.clients, protocolAddr)
-		client = nil
+	if ok && client.IsAvailable() {
+		bc.mu.Unlock()
+		return client, nil
 	}
-	if client == nil {
-		var err error
-		client, err = GeneralDial(protocolAddr, bc.option)
-		if err != nil {
-			return nil, err
+	if ok {
+		// a draining client closes itself once its calls are done
+		if !client.IsDraining() {
+			_ = client.Close()
 		}
-		bc.clients[protocolAddr] = client
+		delete(bc.clients, protocolAddr)
+	}
+	pending, dialing := bc.dials[protocolAddr]
+	if dialing {
+		bc.mu.Unlock()
+		<-pending.done
+		return pending.client, pending.err
+	}
+	pending = &pendingDial{done: make(chan struct{})}
+	bc.dials[protocolAddr] = pending
+	bc.mu.Unlock()
+
+	pending.client, pending.err = GeneralDial(protocolAddr, bc.option)
+	bc.mu.Lock()
+	delete(bc.dials, protocolAddr)
+	if pending.err == nil {
+		bc.clients[protocolAddr] = pending.client
 	}
-	return client, nil
+	bc.mu.Unlock()
+	close(pending.done)
+	return pending.client, pending.err
 }
 
+// dialError marks errors of dialing a server,the call was never sent
+type dialError struct {
+	error
+}
+
+func (e dialError) Unwrap() error {
+	return e.error
+}
+
+// call calls protocolAddr,its circuit breaker and latency stats record the outcome
 func (bc *BalanceClient) call(protocolAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	breaker := bc.breaker(protocolAddr)
+	if !breaker.acquire() {
+		return ErrBreakerOpen
+	}
+	start := time.Now()
 	client, err := bc.dial(protocolAddr)
 	if err != nil {
-		return err
+		err = dialError{err}
+	} else {
+		err = client.Call(ctx, serviceMethod, args, reply)
+	}
+	failed, neutral := err != nil && classify(err)&failures != 0, err != nil && ctx.Err() != nil
+	breaker.record(failed, neutral)
+	if !neutral {
+		bc.latencyStats(protocolAddr).observe(time.Since(start), failed)
+	}
+	return err
+}
+
+// get selects a server hosting the service of serviceMethod by mode,skipping those with an open circuit breaker
+func (bc *BalanceClient) get(ctx context.Context, serviceMethod string, args interface{}) (string, error) {
+	return bc.getFiltered(ctx, serviceMethod, args, bc.available)
+}
+
+// getFiltered is get among the servers filter returns true for
+func (bc *BalanceClient) getFiltered(ctx context.Context, serviceMethod string, args interface{}, filter func(string) bool) (string, error) {
+	return bc.discover.Get(bc.mode, WithFilter(filter), WithLoad(bc.load), WithKey(routingKeyOf(ctx, args)),
+		WithCost(bc.cost), WithLocality(bc.localityPolicy), WithService(serviceOf(serviceMethod)))
+}
+
+// serviceOf returns the service part of serviceMethod
+func serviceOf(serviceMethod string) string {
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		return serviceMethod[:dot]
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	return serviceMethod
+}
+
+// SetLocalityPolicy keeps calls near the client,nil means anywhere.
+// Open circuit breakers make servers unhealthy,so a failing zone spills over to the others.
+// Call it before the client is used.
+func (bc *BalanceClient) SetLocalityPolicy(policy *LocalityPolicy) {
+	bc.localityPolicy = policy
+}
+
+// load returns the calls in flight on protocolAddr,0 without a connection
+func (bc *BalanceClient) load(protocolAddr string) int {
+	bc.mu.Lock()
+	client := bc.clients[protocolAddr]
+	bc.mu.Unlock()
+	if client == nil {
+		return 0
+	}
+	return client.PendingCalls()
+}
+
+// Use adds interceptors around Call,the first one added runs outermost.
+// Call it before the client is used.
+func (bc *BalanceClient) Use(interceptors ...ClientInterceptor) {
+	bc.interceptors = append(bc.interceptors, interceptors...)
 }
 
 func (bc *BalanceClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	protocolAddr, err := bc.discover.Get(bc.mode)
-	if err != nil {
-		return err
+	return ChainClientInterceptors(bc.interceptors, bc.invoke)(ctx, serviceMethod, args, reply)
+}
+
+// invoke selects a server and calls it,hedging or retrying by the policies of serviceMethod,without interceptors
+func (bc *BalanceClient) invoke(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if hedge := bc.hedgePolicies[serviceMethod]; hedge != nil && hedge.MaxAttempts > 1 {
+		return bc.hedge(ctx, serviceMethod, args, reply, hedge)
+	}
+	policy := bc.retryPolicyOf(serviceMethod)
+	if policy == nil || policy.MaxAttempts <= 1 {
+		protocolAddr, err := bc.get(ctx, serviceMethod, args)
+		if err != nil {
+			return err
+		}
+		return bc.call(protocolAddr, ctx, serviceMethod, args, reply)
+	}
+	tried := make(map[string]ErrorClass)
+	for attempt := 1; ; attempt++ {
+		var protocolAddr string
+		var err error
+		if policy.DifferentInstance {
+			protocolAddr, err = bc.selectUntried(ctx, serviceMethod, args, tried)
+		} else {
+			protocolAddr, err = bc.get(ctx, serviceMethod, args)
+		}
+		if err != nil {
+			return err
+		}
+		err = bc.call(protocolAddr, ctx, serviceMethod, args, reply)
+		if err == nil {
+			return nil
+		}
+		class := classify(err)
+		tried[protocolAddr] = class
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil || !policy.retryable(class, bc.idempotent[serviceMethod]) {
+			return err
+		}
+		if !wait(ctx, policy.backoff(attempt)) {
+			return err
+		}
 	}
-	return bc.call(protocolAddr, ctx, serviceMethod, args, reply)
 }
 
 // Broadcast call the named function for every server registered in discovery
@@ -77,20 +229,29 @@ func (bc *BalanceClient) Broadcast(ctx context.Context, serviceMethod string, ar
 	if err != nil {
 		return err
 	}
+	// servers with an open circuit breaker or without the service are left out
+	filter := bc.available
+	if hoster, ok := bc.discover.(interface {
+		Hosts(protocolAddr, service string) bool
+	}); ok {
+		service := serviceOf(serviceMethod)
+		filter = func(protocolAddr string) bool {
+			return hoster.Hosts(protocolAddr, service) && bc.available(protocolAddr)
+		}
+	}
+	services = (&SelectOptions{Filter: filter}).filter(services)
 	var wg sync.WaitGroup
 	var mu sync.Mutex // protect e and replyDone
 	var e error
 	replyDone := reply == nil // if reply is nil, don't need to set value
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, protocolAddr := range services {
 		wg.Add(1)
 		go func(protocolAddr string) {
 			defer wg.Done()
-			var clonedReply interface{}
-			if reply != nil {
-				// one of the calls success,creat a new clonedReply
-				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
-			}
+			// one of the calls success,creat a new clonedReply
+			clonedReply := newReply(reply)
 			err := bc.call(protocolAddr, ctx, serviceMethod, args, clonedReply)
 			mu.Lock()
 			if err != nil && e == nil {
@@ -98,7 +259,7 @@ func (bc *BalanceClient) Broadcast(ctx context.Context, serviceMethod string, ar
 				cancel() // if any call failed, cancel unfinished calls
 			}
 			if err == nil && !replyDone {
-				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
+				setReply(reply, clonedReply)
 				replyDone = true
 			}
 			mu.Unlock()
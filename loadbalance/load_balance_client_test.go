@@ -0,0 +1,103 @@
+package loadbalance
+
+import (
+	. "MicroRPC"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+// Arith counts its calls,Fail fails every call
+type Arith struct {
+	calls atomic.Int32
+}
+
+func (a *Arith) Sum(args Args, reply *int) error {
+	a.calls.Add(1)
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (a *Arith) Fail(args Args, reply *int) error {
+	a.calls.Add(1)
+	return errors.New("fail")
+}
+
+// startServer serves a new Arith on a loopback listener and returns it with its protocol@addr,
+// the server is shut down at the end of the test
+func startServer(t *testing.T, interceptors ...Interceptor) (*Arith, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer()
+	arith := new(Arith)
+	_ = server.Register(arith)
+	server.Use(interceptors...)
+	go server.Accept(l)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+	return arith, "tcp@" + l.Addr().String()
+}
+
+// newBalanceClient balances over servers,the client is closed at the end of the test
+func newBalanceClient(t *testing.T, mode ModeSelect, servers ...string) *BalanceClient {
+	t.Helper()
+	bc := NewBalanceClient(mode, NewDiscovery(servers), nil)
+	t.Cleanup(func() { _ = bc.Close() })
+	return bc
+}
+
+func TestBalanceClientCall(t *testing.T) {
+	a, addrA := startServer(t)
+	b, addrB := startServer(t)
+	bc := newBalanceClient(t, RoundRobinSelect, addrA, addrB)
+	for i := 0; i < 4; i++ {
+		var reply int
+		if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: i, Num2: 1}, &reply); err != nil || reply != i+1 {
+			t.Fatalf("Sum = %d, %v", reply, err)
+		}
+	}
+	if a.calls.Load() != 2 || b.calls.Load() != 2 {
+		t.Fatalf("calls = %d, %d, expect round robin", a.calls.Load(), b.calls.Load())
+	}
+	var reply int
+	if err := bc.Broadcast(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("Broadcast = %d, %v", reply, err)
+	}
+	if a.calls.Load() != 3 || b.calls.Load() != 3 {
+		t.Fatalf("calls = %d, %d, expect one broadcast call each", a.calls.Load(), b.calls.Load())
+	}
+}
+
+func TestBalanceClientService(t *testing.T) {
+	a, addrA := startServer(t)
+	b, addrB := startServer(t)
+	d := NewDiscovery([]string{addrA, addrB})
+	d.SetServiceNames(map[string][]string{addrA: {"Arith"}, addrB: {"Sleeper"}})
+	bc := NewBalanceClient(RoundRobinSelect, d, nil)
+	defer func() { _ = bc.Close() }()
+	for i := 0; i < 4; i++ {
+		if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: i, Num2: 1}, new(int)); err != nil {
+			t.Fatal("Sum:", err)
+		}
+	}
+	if err := bc.Broadcast(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, new(int)); err != nil {
+		t.Fatal("Broadcast:", err)
+	}
+	if a.calls.Load() != 5 || b.calls.Load() != 0 {
+		t.Fatalf("calls = %d, %d, expect all on the server hosting Arith", a.calls.Load(), b.calls.Load())
+	}
+	if err := bc.Call(context.Background(), "Echo.Say", Args{}, new(int)); err == nil {
+		t.Fatal("call of a service no server hosts succeeded")
+	}
+}
@@ -1,6 +1,8 @@
 package loadbalance
 
 import (
+	"MicroRPC/registry"
+	"encoding/json"
 	"log"
 	"net/http"
 	"strings"
@@ -19,7 +21,7 @@ type RegistryDiscovery struct {
 func (rd *RegistryDiscovery) Update(services []string) error {
 	rd.mu.Lock()
 	defer rd.mu.Unlock()
-	rd.services = services
+	rd.setServices(services)
 	rd.lastUpdateTime = time.Now()
 	return nil
 }
@@ -38,22 +40,42 @@ func (rd *RegistryDiscovery) Refresh() error {
 		log.Println("rpc registry refresh err:", err)
 		return err
 	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
 	servers := strings.Split(resp.Header.Get("micro-rpc-servers"), ",")
-	rd.services = make([]string, 0, len(servers))
+	services := make([]string, 0, len(servers))
 	for _, server := range servers {
 		if strings.TrimSpace(server) != "" {
-			rd.services = append(rd.services, strings.TrimSpace(server))
+			services = append(services, strings.TrimSpace(server))
 		}
 	}
+	rd.setServices(services)
+	// newer registries describe the servers in the body,old ones only list them
+	var statuses []registry.ServerStatus
+	rd.weights = make(map[string]int)
+	rd.localities = make(map[string]registry.Locality)
+	hosted := make(map[string][]string)
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err == nil {
+		for _, status := range statuses {
+			rd.weights[status.Address] = status.Weight
+			rd.localities[status.Address] = status.Locality
+			// old servers don't tell their services
+			if len(status.Services) > 0 {
+				hosted[status.Address] = status.Services
+			}
+		}
+	}
+	rd.setServiceNames(hosted)
 	rd.lastUpdateTime = time.Now()
 	return nil
 }
 
-func (rd *RegistryDiscovery) Get(mode ModeSelect) (string, error) {
+func (rd *RegistryDiscovery) Get(mode ModeSelect, opts ...SelectOption) (string, error) {
 	if err := rd.Refresh(); err != nil {
 		return "", err
 	}
-	return rd.Discovery.Get(mode)
+	return rd.Discovery.Get(mode, opts...)
 }
 
 func (rd *RegistryDiscovery) GetAll() ([]string, error) {
@@ -0,0 +1,174 @@
+package loadbalance
+
+import (
+	. "MicroRPC"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrorClass groups the errors a call can fail with,RetryPolicy.RetryOn is a set of them
+type ErrorClass uint8
+
+const (
+	// RetryUnavailable :the server couldn't be dialed or went away before the call was sent
+	RetryUnavailable ErrorClass = 1 << iota
+	// RetryBusy :the server rejected the call with ErrServerBusy
+	RetryBusy
+	// RetryConnectionLost :the connection broke while the call was in flight
+	RetryConnectionLost
+	// RetryTimeout :the server gave up on the call after its handle timeout
+	RetryTimeout
+	// RetryServerError :the service method returned an error
+	RetryServerError
+)
+
+// unsent are the classes of calls the server never started,
+// they are retried for every method,the others only for idempotent ones
+const unsent = RetryUnavailable | RetryBusy
+
+// RetryPolicy tells BalanceClient how to retry a failed call
+type RetryPolicy struct {
+	MaxAttempts int // including the first one,<= 1 means no retry
+	// the n-th retry waits InitialBackoff*2^(n-1),at most MaxBackoff,0 means no cap
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Jitter         float64 // fraction of the backoff taken off at random,0 to 1
+	RetryOn        ErrorClass
+	// DifferentInstance retries on a server not tried yet,as long as there is one
+	DifferentInstance bool
+}
+
+// DefaultRetryPolicy retries transient failures of a node,e.g. during a rolling restart
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:       3,
+	InitialBackoff:    50 * time.Millisecond,
+	MaxBackoff:        time.Second,
+	Jitter:            0.2,
+	RetryOn:           RetryUnavailable | RetryBusy | RetryConnectionLost | RetryTimeout,
+	DifferentInstance: true,
+}
+
+// backoff returns the wait before the retry-th retry
+func (policy *RetryPolicy) backoff(retry int) time.Duration {
+	backoff := policy.InitialBackoff
+	for i := 1; i < retry && (policy.MaxBackoff == 0 || backoff < policy.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	if backoff <= 0 || policy.Jitter <= 0 {
+		return backoff
+	}
+	return backoff - time.Duration(rand.Float64()*policy.Jitter*float64(backoff))
+}
+
+// retryable reports whether a call failed with an error of class may be sent again
+func (policy *RetryPolicy) retryable(class ErrorClass, idempotent bool) bool {
+	if policy.RetryOn&class == 0 {
+		return false
+	}
+	// a call the server may have run is only repeated if that is safe
+	return idempotent || class&unsent != 0
+}
+
+// classify returns the ErrorClass of err returned by a call,
+// 0 means an error retrying won't fix
+func classify(err error) ErrorClass {
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, new(dialError)), err == ErrorShutdown, err == ErrServerShutdown, err == ErrBreakerOpen:
+		return RetryUnavailable
+	case err == ErrServerBusy:
+		return RetryBusy
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &opErr):
+		return RetryConnectionLost
+	case strings.HasPrefix(err.Error(), "rpc server: request handle timeout"):
+		return RetryTimeout
+	// errors of the framework itself,e.g. an unknown method or the caller's context
+	case strings.HasPrefix(err.Error(), "rpc server: "), strings.HasPrefix(err.Error(), "rpc client: "),
+		strings.HasPrefix(err.Error(), "reading body "):
+		return 0
+	}
+	return RetryServerError
+}
+
+// SetRetryPolicy sets the retry policy of every method without an own one,nil means no retry.
+// Call it before the client is used.
+func (bc *BalanceClient) SetRetryPolicy(policy *RetryPolicy) {
+	bc.retryPolicy = policy
+}
+
+// SetMethodRetryPolicy overrides the retry policy of serviceMethod,nil means no retry.
+// Call it before the client is used.
+func (bc *BalanceClient) SetMethodRetryPolicy(serviceMethod string, policy *RetryPolicy) {
+	if bc.methodRetryPolicies == nil {
+		bc.methodRetryPolicies = make(map[string]*RetryPolicy)
+	}
+	bc.methodRetryPolicies[serviceMethod] = policy
+}
+
+// MarkIdempotent marks methods safe to run more than once,only their calls are retried
+// after the server may have started them. Call it before the client is used.
+func (bc *BalanceClient) MarkIdempotent(serviceMethods ...string) {
+	if bc.idempotent == nil {
+		bc.idempotent = make(map[string]bool)
+	}
+	for _, serviceMethod := range serviceMethods {
+		bc.idempotent[serviceMethod] = true
+	}
+}
+
+func (bc *BalanceClient) retryPolicyOf(serviceMethod string) *RetryPolicy {
+	if policy, ok := bc.methodRetryPolicies[serviceMethod]; ok {
+		return policy
+	}
+	return bc.retryPolicy
+}
+
+// selectUntried selects a server by mode,preferring one not in tried,
+// then one that was at least reachable. tried maps servers to the class of their error.
+func (bc *BalanceClient) selectUntried(ctx context.Context, serviceMethod string, args interface{}, tried map[string]ErrorClass) (string, error) {
+	protocolAddr, err := bc.get(ctx, serviceMethod, args)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := tried[protocolAddr]; !ok {
+		return protocolAddr, nil
+	}
+	// the mode and locality still apply among the servers left
+	untried := func(service string) bool {
+		_, ok := tried[service]
+		return !ok && bc.available(service)
+	}
+	reachable := func(service string) bool {
+		class, ok := tried[service]
+		return ok && class != RetryUnavailable && bc.available(service)
+	}
+	for _, filter := range []func(string) bool{untried, reachable} {
+		if service, err := bc.getFiltered(ctx, serviceMethod, args, filter); err == nil {
+			return service, nil
+		}
+	}
+	return protocolAddr, nil
+}
+
+// wait sleeps for d,false if ctx is done first
+func wait(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,112 @@
+package loadbalance
+
+import (
+	. "MicroRPC"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{dialError{errors.New("dial tcp: connection refused")}, RetryUnavailable},
+		{ErrorShutdown, RetryUnavailable},
+		{ErrServerShutdown, RetryUnavailable},
+		{ErrBreakerOpen, RetryUnavailable},
+		{ErrServerBusy, RetryBusy},
+		{io.EOF, RetryConnectionLost},
+		{io.ErrUnexpectedEOF, RetryConnectionLost},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset")}, RetryConnectionLost},
+		{errors.New("rpc server: request handle timeout: expect within 1s"), RetryTimeout},
+		{errors.New("rpc server: can't find method Missing"), 0},
+		{errors.New("rpc client: call failed: context deadline exceeded"), 0},
+		{errors.New("record not found"), RetryServerError},
+	}
+	for _, test := range tests {
+		if class := classify(test.err); class != test.class {
+			t.Errorf("classify(%v) = %d, expect %d", test.err, class, test.class)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	policy := &RetryPolicy{RetryOn: RetryUnavailable | RetryBusy | RetryTimeout}
+	tests := []struct {
+		class      ErrorClass
+		idempotent bool
+		retryable  bool
+	}{
+		{RetryBusy, false, true},
+		{RetryUnavailable, false, true},
+		// the server may have run the call
+		{RetryTimeout, false, false},
+		{RetryTimeout, true, true},
+		// not in RetryOn
+		{RetryServerError, true, false},
+	}
+	for _, test := range tests {
+		if retryable := policy.retryable(test.class, test.idempotent); retryable != test.retryable {
+			t.Errorf("retryable(%d, %v) = %v, expect %v", test.class, test.idempotent, retryable, test.retryable)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	ms := time.Millisecond
+	capped := &RetryPolicy{InitialBackoff: 10 * ms, MaxBackoff: 50 * ms}
+	uncapped := &RetryPolicy{InitialBackoff: 10 * ms}
+	for retry, expect := range []time.Duration{10 * ms, 20 * ms, 40 * ms, 50 * ms, 50 * ms} {
+		if backoff := capped.backoff(retry + 1); backoff != expect {
+			t.Errorf("capped backoff(%d) = %s, expect %s", retry+1, backoff, expect)
+		}
+	}
+	if backoff := uncapped.backoff(5); backoff != 160*ms {
+		t.Errorf("uncapped backoff(5) = %s, expect %s", backoff, 160*ms)
+	}
+	jittered := &RetryPolicy{InitialBackoff: 100 * ms, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if backoff := jittered.backoff(1); backoff < 50*ms || backoff > 100*ms {
+			t.Fatalf("jittered backoff = %s, expect within [50ms, 100ms]", backoff)
+		}
+	}
+}
+
+func TestRetryDifferentInstance(t *testing.T) {
+	busy := func(ctx context.Context, info *RequestInfo, next Handler) error {
+		return ErrServerBusy
+	}
+	a, addrA := startServer(t, busy)
+	b, addrB := startServer(t)
+	bc := newBalanceClient(t, RoundRobinSelect, addrA, addrB)
+	bc.SetRetryPolicy(&RetryPolicy{MaxAttempts: 2, RetryOn: RetryBusy, DifferentInstance: true})
+	for i := 0; i < 4; i++ {
+		var reply int
+		if err := bc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("Sum = %d, %v, expect the busy server to be retried on the other", reply, err)
+		}
+	}
+	if a.calls.Load() != 0 || b.calls.Load() != 4 {
+		t.Fatalf("calls = %d, %d, expect all on the second server", a.calls.Load(), b.calls.Load())
+	}
+
+	// a method error is only retried if RetryOn has it,and the method is idempotent
+	bc = newBalanceClient(t, RoundRobinSelect, addrB)
+	bc.SetMethodRetryPolicy("Arith.Fail", &RetryPolicy{MaxAttempts: 3, RetryOn: RetryServerError})
+	if err := bc.Call(context.Background(), "Arith.Fail", Args{}, new(int)); err == nil {
+		t.Fatal("Fail succeeded")
+	}
+	bc.MarkIdempotent("Arith.Fail")
+	if err := bc.Call(context.Background(), "Arith.Fail", Args{}, new(int)); err == nil {
+		t.Fatal("Fail succeeded")
+	}
+	// 1 call not retried,3 attempts of the idempotent one reaching b
+	if b.calls.Load() != 4+1+3 {
+		t.Fatalf("calls of b = %d, expect %d", b.calls.Load(), 4+1+3)
+	}
+}
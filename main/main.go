@@ -40,7 +40,10 @@ func startServer(registryUrl string, wg *sync.WaitGroup) {
 	l, _ := net.Listen("tcp", ":0")
 	server := MicroRPC.NewServer()
 	_ = server.Register(&wsj)
-	registry.HeartBeat("tcp@"+l.Addr().String(), 0, registryUrl)
+	status := func() registry.ServerStatus {
+		return registry.ServerStatus{Address: "tcp@" + l.Addr().String(), Services: server.Services()}
+	}
+	server.RegisterOnShutdown(registry.HeartBeatStatus(status, 0, registryUrl))
 	wg.Done()
 	server.Accept(l)
 }
@@ -88,8 +91,9 @@ func broadcast(registryUrl string) {
 			defer wg.Done()
 			logPrint("broadcast", "Wsj.Sum", &Args{Num1: i, Num2: i * i}, bc, context.Background())
 			// expect 2 - 5 timeout
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*2)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 			logPrint("broadcast", "Wsj.Sleep", &Args{Num1: i, Num2: i * i}, bc, ctx)
+			cancel()
 		}(i)
 	}
 	wg.Wait()
@@ -0,0 +1,37 @@
+package MicroRPC
+
+import "context"
+
+type outgoingMetadataKey struct{}
+
+type incomingMetadataKey struct{}
+
+// WithMetadata returns a copy of ctx whose calls carry md to the server,
+// md is merged over the metadata already set on ctx
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	merged := make(map[string]string)
+	for key, value := range outgoingMetadata(ctx) {
+		merged[key] = value
+	}
+	for key, value := range md {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, outgoingMetadataKey{}, merged)
+}
+
+// MetadataFromContext returns the metadata the client sent along with the request
+// handled under ctx,for use in service methods and interceptors
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(incomingMetadataKey{}).(map[string]string)
+	return md
+}
+
+// outgoingMetadata returns the metadata set by WithMetadata
+func outgoingMetadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(map[string]string)
+	return md
+}
+
+func withIncomingMetadata(ctx context.Context, md map[string]string) context.Context {
+	return context.WithValue(ctx, incomingMetadataKey{}, md)
+}
@@ -0,0 +1,189 @@
+package MicroRPC
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+	// maxResends bounds how often a call the server never started is sent again
+	maxResends = 3
+)
+
+// ReconnectClient keeps a Client connected to protocolAddr: once the connection dies or the
+// server sends encode.KindGoAway,protocolAddr is dialed again on the next call.
+// Only the calls in flight on the old connection fail,new calls go to the fresh one.
+// While the server can't be reached calls fail at once with the error of the last dial,
+// failed dials are spaced with exponential backoff.
+type ReconnectClient struct {
+	// MinBackoff and MaxBackoff bound the wait between failed dials,set them before the client is used
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	protocolAddr string
+	option       *Option
+	interceptors []ClientInterceptor
+	mu           sync.Mutex // protect following
+	client       *Client
+	closed       bool
+	dialing      chan struct{} // closed once the dial in progress ends,nil without one
+	failures     int           // failed dials in a row
+	retryAt      time.Time     // no dial before,after a failure
+	err          error         // of the last failed dial
+}
+
+// DialReconnect connects to protocolAddr (see GeneralDial) and keeps reconnecting to it
+func DialReconnect(protocolAddr string, opts ...*Option) (*ReconnectClient, error) {
+	option, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	client, err := GeneralDial(protocolAddr, option)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectClient{
+		MinBackoff:   defaultMinBackoff,
+		MaxBackoff:   defaultMaxBackoff,
+		protocolAddr: protocolAddr,
+		option:       option,
+		client:       client,
+	}, nil
+}
+
+// Use adds interceptors around Call,the first one added runs outermost.
+// Call it before the client is used.
+func (rc *ReconnectClient) Use(interceptors ...ClientInterceptor) {
+	rc.interceptors = append(rc.interceptors, interceptors...)
+}
+
+// Close closes the current connection and stops reconnecting
+func (rc *ReconnectClient) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.closed {
+		return ErrorShutdown
+	}
+	rc.closed = true
+	if rc.client == nil {
+		return nil
+	}
+	return rc.client.Close()
+}
+
+var _ io.Closer = (*ReconnectClient)(nil)
+
+// Client returns an available connection,dialing a new one if needed,
+// e.g. to open a stream with CallStream
+func (rc *ReconnectClient) Client(ctx context.Context) (*Client, error) {
+	for {
+		rc.mu.Lock()
+		if rc.closed {
+			rc.mu.Unlock()
+			return nil, ErrorShutdown
+		}
+		if rc.client != nil && rc.client.IsAvailable() {
+			client := rc.client
+			rc.mu.Unlock()
+			return client, nil
+		}
+		// wait for the dial in progress,it is bounded by Option.ConnectTimeout
+		if dialing := rc.dialing; dialing != nil {
+			rc.mu.Unlock()
+			select {
+			case <-dialing:
+			case <-ctx.Done():
+				return nil, errors.New("rpc client: reconnect failed: " + ctx.Err().Error())
+			}
+			continue
+		}
+		// the backoff only delays the next dial,callers don't wait for it
+		if time.Now().Before(rc.retryAt) {
+			err := rc.err
+			rc.mu.Unlock()
+			return nil, errors.New("rpc client: reconnect failed: " + err.Error())
+		}
+		rc.dialing = make(chan struct{})
+		rc.mu.Unlock()
+		if err := rc.redial(); err != nil {
+			return nil, errors.New("rpc client: reconnect failed: " + err.Error())
+		}
+	}
+}
+
+// redial replaces the current connection,only one runs at a time
+func (rc *ReconnectClient) redial() error {
+	client, err := GeneralDial(rc.protocolAddr, rc.option)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	close(rc.dialing)
+	rc.dialing = nil
+	if err != nil {
+		rc.failures++
+		rc.retryAt = time.Now().Add(rc.backoff(rc.failures))
+		rc.err = err
+		return err
+	}
+	if rc.closed {
+		_ = client.Close()
+		return nil
+	}
+	// a draining connection closes itself once its calls are done
+	if old := rc.client; old != nil && !old.IsDraining() {
+		_ = old.Close()
+	}
+	rc.client, rc.failures, rc.err = client, 0, nil
+	return nil
+}
+
+// backoff doubles with every failure up to MaxBackoff,with up to 20% jitter
+// so clients of a restarted server don't dial in lockstep
+func (rc *ReconnectClient) backoff(failures int) time.Duration {
+	backoff := rc.MinBackoff
+	for i := 1; i < failures && backoff < rc.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > rc.MaxBackoff {
+		backoff = rc.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff - time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+func (rc *ReconnectClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return ChainClientInterceptors(rc.interceptors, rc.invoke)(ctx, serviceMethod, args, reply)
+}
+
+// invoke calls the current connection,without interceptors.
+// Calls the server never started are sent again on the next connection,
+// up to maxResends times with backoff in between.
+func (rc *ReconnectClient) invoke(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	for resends := 0; ; resends++ {
+		if resends > 0 {
+			timer := time.NewTimer(rc.backoff(resends))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return errors.New("rpc client: call failed: " + ctx.Err().Error())
+			}
+		}
+		client, err := rc.Client(ctx)
+		if err != nil {
+			return err
+		}
+		err = client.invoke(ctx, serviceMethod, args, reply)
+		// ErrorShutdown: the connection went away before the call was sent
+		if (err != ErrorShutdown && err != ErrServerShutdown) || resends == maxResends {
+			return err
+		}
+	}
+}
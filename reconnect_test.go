@@ -0,0 +1,104 @@
+package MicroRPC
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectServerDown(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	rc, err := DialReconnect(startServer(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rc.Close() }()
+	var reply int
+	if err := rc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-shutdown(server, time.Second); err != nil {
+		t.Fatal("shutdown:", err)
+	}
+	// the first call dials and fails,the second one fails within the backoff
+	for i := 0; i < 2; i++ {
+		done := make(chan error, 1)
+		go func() {
+			done <- rc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Fatal("call succeeded with the server down")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("call blocked with the server down")
+		}
+	}
+}
+
+func TestReconnectAfterRestart(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	go server.Accept(l)
+	rc, err := DialReconnect("tcp@" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rc.Close() }()
+	rc.MinBackoff, rc.MaxBackoff = time.Millisecond, 10*time.Millisecond
+	if err := <-shutdown(server, time.Second); err != nil {
+		t.Fatal("shutdown:", err)
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewServer()
+	_ = restarted.Register(new(Arith))
+	go restarted.Accept(l)
+	defer func() { _ = <-shutdown(restarted, time.Second) }()
+
+	// the call goes to the restarted server instead of the connection told to go away
+	var reply int
+	if err := rc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("Sum = %d, %v", reply, err)
+	}
+}
+
+func TestReconnectResendLimit(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	var calls atomic.Int32
+	// every request looks like it arrived after Shutdown started
+	server.Use(func(ctx context.Context, info *RequestInfo, next Handler) error {
+		calls.Add(1)
+		return ErrServerShutdown
+	})
+	rc, err := DialReconnect(startServer(t, server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rc.Close() }()
+	rc.MinBackoff, rc.MaxBackoff = 10*time.Millisecond, time.Second
+	start := time.Now()
+	if err := rc.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, new(int)); err != ErrServerShutdown {
+		t.Fatalf("Sum = %v, expect %v", err, ErrServerShutdown)
+	}
+	if n := calls.Load(); n != maxResends+1 {
+		t.Fatalf("sent %d times, expect %d", n, maxResends+1)
+	}
+	// 10ms,20ms and 40ms less 20% jitter
+	if elapsed := time.Since(start); elapsed < 56*time.Millisecond {
+		t.Fatalf("resent within %s, expect backoff in between", elapsed)
+	}
+}
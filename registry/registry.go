@@ -1,6 +1,8 @@
 package registry
 
 import (
+	"bytes"
+	"encoding/json"
 	"log"
 	"net/http"
 	"sort"
@@ -14,11 +16,26 @@ const (
 	defaultTimeout = time.Minute * 5
 )
 
+// ServerStatus is the registry entry of a server,
+// sent as the JSON body of a heartbeat and listed in the JSON body of GET
 type ServerStatus struct {
-	Address   string
+	Address string
+	Weight  int `json:",omitempty"` // relative capacity for weighted selection,0 means 1
+	// Services are the names of the services the server hosts,see Server.Services.
+	// Servers without them are taken to host every service.
+	Services []string `json:",omitempty"`
+	Locality
 	startTime time.Time
 }
 
+// Locality tells where a server runs,clients prefer servers near them,see loadbalance.LocalityPolicy.
+// Empty labels match nothing.
+type Locality struct {
+	Region string `json:",omitempty"`
+	Zone   string `json:",omitempty"` // within Region
+	Rack   string `json:",omitempty"` // within Zone,informational,routing stops at the zone
+}
+
 // Registry :register center
 // receive heartbeat,make sure server alive
 type Registry struct {
@@ -28,46 +45,57 @@ type Registry struct {
 }
 
 // addServer add a new server
-// if a server has existed, refresh its startTime
-func (r *Registry) addServer(address string) {
+// if a server has existed, refresh its startTime and status
+func (r *Registry) addServer(status ServerStatus) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	s := r.servers[address]
-	if s == nil {
-		r.servers[address] = &ServerStatus{Address: address, startTime: time.Now()}
-	} else {
-		s.startTime = time.Now()
-	}
+	status.startTime = time.Now()
+	r.servers[status.Address] = &status
+}
+
+// removeServer removes a server that is shutting down
+func (r *Registry) removeServer(address string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.servers, address)
 }
 
-// returnAliveServers: return alive servers addresses
+// returnAliveStatus: return the status of alive servers,sorted by address
 // if a server timeout,delete it
-func (r *Registry) returnAliveServers() []string {
+func (r *Registry) returnAliveStatus() []ServerStatus {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	var aliveServers []string
+	var aliveStatus []ServerStatus
 	for address, server := range r.servers {
 		if r.timeout == 0 || server.startTime.Add(r.timeout).After(time.Now()) {
-			aliveServers = append(aliveServers, address)
+			aliveStatus = append(aliveStatus, *server)
 		} else {
 			delete(r.servers, address)
 		}
 	}
 	// sort by key:address
-	sort.Strings(aliveServers)
-	return aliveServers
+	sort.Slice(aliveStatus, func(i, j int) bool { return aliveStatus[i].Address < aliveStatus[j].Address })
+	return aliveStatus
 }
 
 // Runs at /micro-rpc/registry
 // A simple implementation,put server on req.Header
-// GET: return all alive servers
-// PUT: add new server or send heartbeat
+// GET: return all alive servers,their ServerStatus in the JSON body
+// PUT: add new server or send heartbeat,optionally with its ServerStatus as JSON body
+// DELETE: remove a server that is shutting down
 func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
 		// put server on req.Header
 		// custom field name "micro-rpc-servers"
-		w.Header().Set("micro-rpc-servers", strings.Join(r.returnAliveServers(), ","))
+		aliveStatus := r.returnAliveStatus()
+		aliveServers := make([]string, 0, len(aliveStatus))
+		for _, status := range aliveStatus {
+			aliveServers = append(aliveServers, status.Address)
+		}
+		w.Header().Set("micro-rpc-servers", strings.Join(aliveServers, ","))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(aliveStatus)
 	case "POST":
 		// custom field name "micro-rpc-server"
 		address := req.Header.Get("micro-rpc-server")
@@ -75,7 +103,23 @@ func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.addServer(address)
+		// old servers send the header only
+		var status ServerStatus
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&status); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		status.Address = address
+		r.addServer(status)
+	case "DELETE":
+		address := req.Header.Get("micro-rpc-server")
+		if address == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.removeServer(address)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -99,32 +143,80 @@ func HandleHTTP() {
 	DefaultRegistry.HandleHTTP(defaultPath)
 }
 
-// HeartBeat send a heartbeat message every once in a while
-func HeartBeat(serverAddr string, duration time.Duration, registryUrl string) {
+// HeartBeat send a heartbeat message every once in a while,
+// stop ends the heartbeats and deregisters the server,e.g. pass it to Server.RegisterOnShutdown
+func HeartBeat(serverAddr string, duration time.Duration, registryUrl string) (stop func()) {
+	return HeartBeatStatus(func() ServerStatus { return ServerStatus{Address: serverAddr} }, duration, registryUrl)
+}
+
+// HeartBeatStatus is HeartBeat advertising the ServerStatus returned by status,
+// e.g. the Weight,Services and Locality of the server. status is called for every heartbeat,
+// so services registered later are advertised with the next one.
+func HeartBeatStatus(status func() ServerStatus, duration time.Duration, registryUrl string) (stop func()) {
+	serverAddr := status().Address
 	if duration == 0 {
 		// 4 min
 		duration = defaultTimeout - time.Duration(1)*time.Minute
 	}
 	var err error
-	err = sendHeartBeat(serverAddr, registryUrl)
+	err = sendHeartBeat(status(), registryUrl)
+	done := make(chan struct{})
+	exited := make(chan struct{})
 	go func() {
+		defer close(exited)
 		t := time.NewTicker(duration)
-		// always send heartbeat until err occurs
+		defer t.Stop()
+		// always send heartbeat until err occurs or stopped
 		for err == nil {
-			<-t.C
-			err = sendHeartBeat(serverAddr, registryUrl)
+			select {
+			case <-t.C:
+				err = sendHeartBeat(status(), registryUrl)
+			case <-done:
+				return
+			}
 		}
 	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			// a heartbeat still being sent would register the server again
+			<-exited
+			_ = Deregister(serverAddr, registryUrl)
+		})
+	}
 }
 
-func sendHeartBeat(serverAddr string, registryUrl string) error {
-	log.Println(serverAddr, "send heart beat to registry", registryUrl)
+func sendHeartBeat(status ServerStatus, registryUrl string) error {
+	log.Println(status.Address, "send heart beat to registry", registryUrl)
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
 	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registryUrl, nil)
-	req.Header.Set("micro-rpc-server", serverAddr)
-	if _, err := httpClient.Do(req); err != nil {
+	req, _ := http.NewRequest("POST", registryUrl, bytes.NewReader(body))
+	req.Header.Set("micro-rpc-server", status.Address)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
 		log.Println("rpc server: heart beat err:", err)
 		return err
 	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// Deregister removes the server from the registry at once,instead of waiting for its heartbeat to time out
+func Deregister(serverAddr string, registryUrl string) error {
+	log.Println(serverAddr, "deregister from registry", registryUrl)
+	httpClient := &http.Client{}
+	req, _ := http.NewRequest("DELETE", registryUrl, nil)
+	req.Header.Set("micro-rpc-server", serverAddr)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Println("rpc server: deregister err:", err)
+		return err
+	}
+	_ = resp.Body.Close()
 	return nil
 }
@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHeartBeatStopDeregisters(t *testing.T) {
+	r := NewRegistry(0)
+	var posts atomic.Int32
+	// heartbeats after the first one are slow,one is being sent when stop is called
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" && posts.Add(1) > 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	stop := HeartBeat("tcp@127.0.0.1:1", 10*time.Millisecond, srv.URL)
+	if alive := r.returnAliveStatus(); len(alive) != 1 {
+		t.Fatalf("alive servers = %v, expect the registered one", alive)
+	}
+	time.Sleep(30 * time.Millisecond)
+	stop()
+	// a heartbeat sent before stop would land by now
+	time.Sleep(150 * time.Millisecond)
+	if alive := r.returnAliveStatus(); len(alive) != 0 {
+		t.Fatalf("alive servers = %v after stop, expect none", alive)
+	}
+}
+
+func TestRegistryStatus(t *testing.T) {
+	r := NewRegistry(0)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	status := func() ServerStatus { return ServerStatus{Address: "tcp@127.0.0.1:1", Weight: 3} }
+	stop := HeartBeatStatus(status, time.Minute, srv.URL)
+	defer stop()
+	// an old server sends the header only
+	req, _ := http.NewRequest("POST", srv.URL, nil)
+	req.Header.Set("micro-rpc-server", "tcp@127.0.0.1:2")
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var statuses []ServerStatus
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	expect := []ServerStatus{{Address: "tcp@127.0.0.1:1", Weight: 3}, {Address: "tcp@127.0.0.1:2"}}
+	if !reflect.DeepEqual(statuses, expect) {
+		t.Fatalf("statuses = %+v, expect %+v", statuses, expect)
+	}
+	if servers := resp.Header.Get("micro-rpc-servers"); servers != "tcp@127.0.0.1:1,tcp@127.0.0.1:2" {
+		t.Fatalf("micro-rpc-servers = %s", servers)
+	}
+}
+
+func TestHeartBeatStatusServices(t *testing.T) {
+	r := NewRegistry(0)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	var services atomic.Pointer[[]string]
+	services.Store(&[]string{"Arith"})
+	status := func() ServerStatus { return ServerStatus{Address: "tcp@127.0.0.1:1", Services: *services.Load()} }
+	stop := HeartBeatStatus(status, 10*time.Millisecond, srv.URL)
+	defer stop()
+	if alive := r.returnAliveStatus(); len(alive) != 1 || !reflect.DeepEqual(alive[0].Services, []string{"Arith"}) {
+		t.Fatalf("alive servers = %+v, expect one hosting Arith", alive)
+	}
+	// a service registered later is advertised with the next heartbeat
+	services.Store(&[]string{"Arith", "Sleeper"})
+	time.Sleep(50 * time.Millisecond)
+	if alive := r.returnAliveStatus(); len(alive) != 1 || !reflect.DeepEqual(alive[0].Services, []string{"Arith", "Sleeper"}) {
+		t.Fatalf("alive servers = %+v, expect one hosting Arith,Sleeper", alive)
+	}
+}
@@ -2,6 +2,8 @@ package MicroRPC
 
 import (
 	"MicroRPC/encode"
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,31 +12,200 @@ import (
 	"net"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const rpcNumber = 0x3bef5c
 
+const (
+	protocolVersion    = 1 // newest protocol version spoken by this package
+	minProtocolVersion = 1 // oldest protocol version still accepted
+)
+
+// Capabilities are optional features of the protocol a peer may support
+const (
+	CapabilityCompress = "compress"
+	CapabilityFraming  = "framing"
+	CapabilityCancel   = "cancel"   // the server cancels requests on encode.KindCancel
+	CapabilityDeadline = "deadline" // the server enforces encode.Header.Timeout
+	CapabilityStream   = "stream"   // the server serves server-streaming methods
+	// CapabilityClientStream :the server serves client-streaming and bidirectional methods
+	CapabilityClientStream = "client-stream"
+	// CapabilityFlowControl :stream senders wait for encode.KindWindow credit
+	CapabilityFlowControl = "flow-control"
+	CapabilityGoAway      = "goaway" // the server sends encode.KindGoAway on Shutdown
+)
+
+// supportedCapabilities lists every capability of this package
+var supportedCapabilities = []string{
+	CapabilityCompress, CapabilityFraming, CapabilityCancel, CapabilityDeadline, CapabilityStream,
+	CapabilityClientStream, CapabilityFlowControl, CapabilityGoAway,
+}
+
 type Option struct {
-	RPCNumber      int
+	RPCNumber int
+	// Version is the newest protocol version the client speaks,
+	// 0 means an old client which doesn't wait for a Negotiation.
+	// Old servers never send one,after negotiationTimeout the client dials them again as Version 0
+	// unless it needs a capability.
+	Version int
+	// Capabilities the client supports
+	Capabilities   []string
 	EncodingType   encode.Type
 	ConnectTimeout time.Duration
 	HandleTimeout  time.Duration
+	// CompressType compresses bodies of at least CompressThreshold bytes,
+	// empty means no compression
+	CompressType      encode.CompressType
+	CompressThreshold int
+	// Framing wraps every message in an encode.FrameCodeProcess frame
+	Framing bool
 }
 
 var DefaultOption = &Option{
 	RPCNumber:      rpcNumber,
+	Version:        protocolVersion,
+	Capabilities:   supportedCapabilities,
 	EncodingType:   encode.GobType,
 	ConnectTimeout: time.Second * 10,
 }
 
+// requiredCapabilities returns the capabilities the features chosen in option depend on
+func (option *Option) requiredCapabilities() []string {
+	var required []string
+	if option.CompressType != "" {
+		required = append(required, CapabilityCompress)
+	}
+	if option.Framing {
+		required = append(required, CapabilityFraming)
+	}
+	return required
+}
+
+// Negotiation is the server's answer to an Option carrying a Version,
+// sent as one line of JSON before switching to the code process
+type Negotiation struct {
+	Version      int
+	Capabilities []string // capabilities both sides support
+	Error        string
+}
+
+func negotiate(option *Option) *Negotiation {
+	negotiation := &Negotiation{Version: option.Version}
+	if negotiation.Version > protocolVersion {
+		negotiation.Version = protocolVersion
+	}
+	if negotiation.Version < minProtocolVersion {
+		negotiation.Error = fmt.Sprintf("unsupported protocol version %d, expect %d to %d",
+			option.Version, minProtocolVersion, protocolVersion)
+		return negotiation
+	}
+	for _, capability := range option.Capabilities {
+		if hasCapability(supportedCapabilities, capability) {
+			negotiation.Capabilities = append(negotiation.Capabilities, capability)
+		}
+	}
+	return negotiation
+}
+
+// check reports whether the client can go on with option after negotiation
+func (negotiation *Negotiation) check(option *Option) error {
+	if negotiation.Error != "" {
+		return errors.New(negotiation.Error)
+	}
+	if negotiation.Version < minProtocolVersion || negotiation.Version > option.Version {
+		return fmt.Errorf("incompatible protocol version %d", negotiation.Version)
+	}
+	for _, capability := range option.requiredCapabilities() {
+		if !hasCapability(negotiation.Capabilities, capability) {
+			return fmt.Errorf("server does not support %s", capability)
+		}
+	}
+	return nil
+}
+
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
 type Server struct {
+	// MaxHandleTimeout caps the handle timeout of every request,0 means no cap
+	MaxHandleTimeout time.Duration
+	// MaxRequests caps the requests handled at once by the server,0 means no cap.
+	// A service method still running after its request timed out or was cancelled counts too.
+	MaxRequests int
+	// MaxConnRequests caps the requests handled at once on one connection,0 means no cap
+	MaxConnRequests int
+	// BusyWait is how long the reader of a connection pauses for a free slot once a cap is hit,
+	// then the request is rejected with ErrServerBusy. 0 rejects at once.
+	// A paused reader doesn't read cancel and stream messages of the connection either.
+	BusyWait time.Duration
 	// locked
-	services sync.Map // key:service.name value:service
+	services     sync.Map // key:service.name value:service
+	interceptors []Interceptor
+	slots        chan struct{} // one per request being handled,nil without MaxRequests
+	slotsOnce    sync.Once
+	active       atomic.Int64 // requests being handled or whose method still runs
+	inShutdown   atomic.Bool
+	mu           sync.Mutex // protect following
+	listeners    map[net.Listener]struct{}
+	conns        map[*serverConn]struct{}
+	onShutdown   []func()
+}
+
+// ErrServerBusy is returned for requests rejected because MaxRequests or MaxConnRequests was hit
+var ErrServerBusy = errors.New("rpc server: server busy")
+
+// ErrServerShutdown is returned for requests arriving after Shutdown started
+var ErrServerShutdown = errors.New("rpc server: server is shutting down")
+
+// serverConn is a connection being served,tracked for Shutdown
+type serverConn struct {
+	cp           encode.CodeProcess
+	sending      *sync.Mutex
+	capabilities []string
+	goAwayOnce   sync.Once
 }
 
+// goAway tells the client to stop sending new requests,once
+func (conn *serverConn) goAway() {
+	if !hasCapability(conn.capabilities, CapabilityGoAway) {
+		return
+	}
+	conn.goAwayOnce.Do(func() {
+		conn.sending.Lock()
+		defer conn.sending.Unlock()
+		if err := conn.cp.Write(&encode.Header{Kind: encode.KindGoAway}, struct{}{}); err != nil {
+			log.Println("rpc server: write goaway error:", err)
+		}
+	})
+}
+
+// RequestInfo describes the request an Interceptor wraps
+type RequestInfo struct {
+	ServiceMethod string
+	Seq           uint64
+	Argv          interface{} // decoded argument
+	Replyv        interface{} // pointer to the reply,filled in by the service method
+	Metadata      map[string]string
+}
+
+// Handler calls the service method of a request
+type Handler func(ctx context.Context, info *RequestInfo) error
+
+// Interceptor runs around the service method,
+// returning without calling next short-circuits the request with the returned error
+type Interceptor func(ctx context.Context, info *RequestInfo, next Handler) error
+
 func NewServer() *Server {
 	return &Server{}
 }
@@ -47,15 +218,64 @@ func Register(instance interface{}) error {
 	return DefaultServer.Register(instance)
 }
 
+// RegisterProto publishes the receiver's proto-only methods in the DefaultServer.
+func RegisterProto(instance interface{}) error {
+	return DefaultServer.RegisterProto(instance)
+}
+
+// Use adds interceptors to the DefaultServer.
+func Use(interceptors ...Interceptor) {
+	DefaultServer.Use(interceptors...)
+}
+
+// Use adds interceptors around every service method of the server,
+// the first one added runs outermost. Call it before the server accepts connections.
+func (server *Server) Use(interceptors ...Interceptor) {
+	server.interceptors = append(server.interceptors, interceptors...)
+}
+
+// intercept calls handler through all interceptors
+func (server *Server) intercept(ctx context.Context, info *RequestInfo, handler Handler) error {
+	for i := len(server.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := server.interceptors[i], handler
+		handler = func(ctx context.Context, info *RequestInfo) error {
+			return interceptor(ctx, info, next)
+		}
+	}
+	return handler(ctx, info)
+}
+
 // Register publishes in the server the set of methods of the
 func (server *Server) Register(instance interface{}) error {
-	s := newService(instance)
+	return server.register(newService(instance, false))
+}
+
+// RegisterProto publishes only the methods whose argv and replyv implement proto.Message,
+// for streaming methods the messages of their streams,
+// so the service can be served with encode.ProtobufType
+func (server *Server) RegisterProto(instance interface{}) error {
+	return server.register(newService(instance, true))
+}
+
+func (server *Server) register(s *service) error {
 	if _, duplicated := server.services.LoadOrStore(s.name, s); duplicated {
 		return errors.New("rpc: service already defined: " + s.name)
 	}
 	return nil
 }
 
+// Services returns the names of the registered services,sorted,
+// e.g. to advertise them in registry.ServerStatus
+func (server *Server) Services() []string {
+	var names []string
+	server.services.Range(func(name, _ interface{}) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
 func (server *Server) findService(serviceMethod string) (s *service, m *method, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
@@ -81,25 +301,168 @@ func Accept(lis net.Listener) {
 	DefaultServer.Accept(lis)
 }
 
-// Accept for every lis
+// Accept for every lis,until lis fails or Shutdown closes it
 func (server *Server) Accept(lis net.Listener) {
+	if !server.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			if !server.inShutdown.Load() {
+				log.Println("rpc server: accept error:", err)
+			}
 			return
 		}
 		go server.ConnectServer(conn)
 	}
 }
 
+// trackListener adds or removes lis,false means the server is shutting down
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.listeners, lis)
+		return true
+	}
+	if server.inShutdown.Load() {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn adds or removes conn,false means the server is shutting down
+func (server *Server) trackConn(conn *serverConn, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.conns, conn)
+		return true
+	}
+	if server.inShutdown.Load() {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[*serverConn]struct{})
+	}
+	server.conns[conn] = struct{}{}
+	return true
+}
+
+// RegisterOnShutdown registers a function to call on Shutdown,
+// e.g. the stop returned by registry.HeartBeat. Each runs in its own goroutine,
+// Shutdown waits for them to return.
+func (server *Server) RegisterOnShutdown(f func()) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.onShutdown = append(server.onShutdown, f)
+}
+
+// shutdownPollInterval is how often Shutdown checks for requests still being handled
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown gracefully shuts down the server: it closes the listeners passed to Accept,
+// sends encode.KindGoAway to every connection,rejects new requests with ErrServerShutdown
+// and waits for the requests being handled. Connections told to go away are still read
+// until their clients close them,so requests sent before the client saw encode.KindGoAway
+// are answered. Other connections are closed once no request is being handled.
+// Once ctx is done the connections are closed anyway,Shutdown stops waiting
+// for the functions of RegisterOnShutdown and ctx.Err() is returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	server.inShutdown.Store(true)
+	onShutdown := make(chan struct{})
+	hooks := new(sync.WaitGroup)
+	for _, f := range server.onShutdown {
+		hooks.Add(1)
+		go func() {
+			defer hooks.Done()
+			f()
+		}()
+	}
+	go func() {
+		hooks.Wait()
+		close(onShutdown)
+	}()
+	for lis := range server.listeners {
+		_ = lis.Close()
+	}
+	conns := make([]*serverConn, 0, len(server.conns))
+	for conn := range server.conns {
+		conns = append(conns, conn)
+	}
+	server.mu.Unlock()
+	for _, conn := range conns {
+		conn.goAway()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	var err error
+	for err == nil && (server.active.Load() > 0 || server.awaitingClients()) {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	// ends the read loops,handlers still running see their context cancelled
+	server.mu.Lock()
+	for conn := range server.conns {
+		_ = conn.cp.Close()
+	}
+	server.mu.Unlock()
+	if err == nil {
+		select {
+		case <-onShutdown:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// awaitingClients reports whether a connection told to go away is still open
+func (server *Server) awaitingClients() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for conn := range server.conns {
+		if hasCapability(conn.capabilities, CapabilityGoAway) {
+			return true
+		}
+	}
+	return false
+}
+
+// startRequest counts a new request,false once Shutdown started
+func (server *Server) startRequest() bool {
+	server.active.Add(1)
+	if server.inShutdown.Load() {
+		server.active.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (server *Server) finishRequest() {
+	server.active.Add(-1)
+}
+
 func (server *Server) ConnectServer(conn io.ReadWriteCloser) {
 	defer func() {
 		_ = conn.Close()
 	}()
 	var option Option
+	decoder := json.NewDecoder(conn)
 	// Decode(must be a pointer)
-	if err := json.NewDecoder(conn).Decode(&option); err != nil {
+	if err := decoder.Decode(&option); err != nil {
 		log.Println("rpc server: options error: ", err)
 		return
 	}
@@ -107,12 +470,83 @@ func (server *Server) ConnectServer(conn io.ReadWriteCloser) {
 		log.Printf("rpc server: invalid rpc number %x", option.RPCNumber)
 		return
 	}
+	cp, err := newCodeProcess(newHandshakeConn(conn, decoder), &option)
+	// old clients don't wait for a negotiation
+	if option.Version == 0 {
+		if err != nil {
+			log.Println("rpc server:", err)
+			return
+		}
+		server.serverProcess(cp, &option)
+		return
+	}
+	negotiation := negotiate(&option)
+	if negotiation.Error == "" && err != nil {
+		negotiation.Error = err.Error()
+	}
+	if err := json.NewEncoder(conn).Encode(negotiation); err != nil {
+		log.Println("rpc server: negotiation error: ", err)
+		return
+	}
+	if negotiation.Error != "" {
+		log.Println("rpc server: negotiation error:", negotiation.Error)
+		return
+	}
+	// from now on only what both sides support counts
+	option.Capabilities = negotiation.Capabilities
+	server.serverProcess(cp, &option)
+}
+
+// newCodeProcess builds the code process described by option,used by both sides of a connection
+func newCodeProcess(conn io.ReadWriteCloser, option *Option) (encode.CodeProcess, error) {
 	f := encode.NewCodeProcessMap[option.EncodingType]
 	if f == nil {
-		log.Printf("rpc server: invalid encoding type %s", option.EncodingType)
-		return
+		return nil, fmt.Errorf("invalid encoding type %s", option.EncodingType)
+	}
+	if option.CompressType == "" && !option.Framing {
+		return f(conn), nil
+	}
+	var compressor encode.Compressor
+	if option.CompressType != "" {
+		if compressor = encode.CompressorMap[option.CompressType]; compressor == nil {
+			return nil, fmt.Errorf("invalid compress type %s", option.CompressType)
+		}
+	}
+	serializer := encode.SerializerMap[option.EncodingType]
+	if serializer == nil {
+		return nil, fmt.Errorf("encoding type %s does not support compression or framing", option.EncodingType)
+	}
+	// frames carry the compressed flag themselves
+	if option.Framing {
+		return encode.NewFrameCodeProcess(conn, serializer, compressor, option.CompressThreshold), nil
+	}
+	return encode.NewCompressCodeProcess(f(conn), serializer, compressor, option.CompressThreshold), nil
+}
+
+// handshakeConn continues reading right after a JSON handshake message,
+// skipping the newline json.Encoder writes behind it
+type handshakeConn struct {
+	io.ReadWriteCloser
+	reader  *bufio.Reader
+	started bool
+}
+
+// newHandshakeConn :the decoder may have read past the message,those bytes are read first
+func newHandshakeConn(conn io.ReadWriteCloser, decoder *json.Decoder) *handshakeConn {
+	return &handshakeConn{
+		ReadWriteCloser: conn,
+		reader:          bufio.NewReader(io.MultiReader(decoder.Buffered(), conn)),
 	}
-	server.serverProcess(f(conn), &option)
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		if b, err := c.reader.Peek(1); err == nil && b[0] == '\n' {
+			_, _ = c.reader.Discard(1)
+		}
+	}
+	return c.reader.Read(p)
 }
 
 // request stores all information of a call
@@ -122,6 +556,160 @@ type request struct {
 	_service     *service
 	_method      *method
 	argv, replyv reflect.Value
+	queue        *streamQueue // arguments of a client-streaming method
+	window       *sendWindow  // replies of a streaming method the client has room for
+	returned     func()       // called once the service method has returned,nil for none
+}
+
+// inflightCall is a request being handled,control messages of the client are routed to it
+type inflightCall struct {
+	cancel   context.CancelFunc
+	streamer clientStreamer
+	queue    *streamQueue
+	window   *sendWindow
+}
+
+// inflightCalls maps the Seq of requests being handled on a connection to their inflightCall
+type inflightCalls struct {
+	cp      encode.CodeProcess
+	sending *sync.Mutex
+	flow    bool // the client supports CapabilityFlowControl
+	mu      sync.Mutex
+	calls   map[uint64]*inflightCall
+}
+
+// add registers req and binds its streams,the returned ctx is cancelled on remove
+func (calls *inflightCalls) add(ctx context.Context, req *request) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	call := &inflightCall{cancel: cancel}
+	if req._method.clientStream {
+		call.streamer = req.argv.Interface().(clientStreamer)
+		call.queue = newStreamQueue(calls.grant(req.header.Seq))
+		call.streamer.bind(call.queue)
+		req.queue = call.queue
+	}
+	if req._method.stream && calls.flow {
+		call.window = newSendWindow()
+		req.window = call.window
+	}
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	calls.calls[req.header.Seq] = call
+	return ctx
+}
+
+func (calls *inflightCalls) remove(seq uint64) {
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	if call := calls.calls[seq]; call != nil {
+		call.cancel()
+		delete(calls.calls, seq)
+	}
+}
+
+// grant returns how read arguments of the stream seq are granted back to the client,
+// nil without flow control
+func (calls *inflightCalls) grant(seq uint64) func(credit int) {
+	if !calls.flow {
+		return nil
+	}
+	return func(credit int) {
+		header := &encode.Header{Seq: seq, Kind: encode.KindWindow, Credit: uint32(credit)}
+		calls.sending.Lock()
+		defer calls.sending.Unlock()
+		if err := calls.cp.Write(header, struct{}{}); err != nil {
+			log.Println("rpc server: write window error:", err)
+		}
+	}
+}
+
+// receive handles a control message,messages of finished requests are dropped
+func (calls *inflightCalls) receive(header *encode.Header) error {
+	calls.mu.Lock()
+	call := calls.calls[header.Seq]
+	calls.mu.Unlock()
+	switch header.Kind {
+	case encode.KindCancel:
+		// the client gave up,stop the handler
+		calls.remove(header.Seq)
+	case encode.KindStream:
+		if call != nil && call.queue != nil {
+			message := call.streamer.newMessage()
+			if err := calls.cp.ReadBody(message); err != nil {
+				log.Println("rpc server: read stream body err:", err)
+				call.queue.finish(err)
+				return nil
+			}
+			call.queue.push(message)
+			return nil
+		}
+	case encode.KindStreamEnd:
+		if call != nil && call.queue != nil {
+			call.queue.finish(io.EOF)
+		}
+	case encode.KindWindow:
+		if call != nil {
+			call.window.grant(int(header.Credit))
+		}
+	}
+	return calls.cp.ReadBody(nil)
+}
+
+// acquireSlot takes a slot of slots,waiting until wait fires when there is none.
+// nil slots never run out,a nil wait doesn't wait.
+func acquireSlot(slots chan struct{}, wait <-chan time.Time) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if wait == nil {
+		return false
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-wait:
+		return false
+	}
+}
+
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+// acquireSlots takes a slot of the connection and one of the server,
+// false means the request has to be rejected
+func (server *Server) acquireSlots(connSlots chan struct{}) bool {
+	server.slotsOnce.Do(func() {
+		if server.MaxRequests > 0 {
+			server.slots = make(chan struct{}, server.MaxRequests)
+		}
+	})
+	var wait <-chan time.Time
+	if server.BusyWait > 0 && (connSlots != nil || server.slots != nil) {
+		timer := time.NewTimer(server.BusyWait)
+		defer timer.Stop()
+		wait = timer.C
+	}
+	if !acquireSlot(connSlots, wait) {
+		return false
+	}
+	if !acquireSlot(server.slots, wait) {
+		releaseSlot(connSlots)
+		return false
+	}
+	return true
+}
+
+func (server *Server) releaseSlots(connSlots chan struct{}) {
+	releaseSlot(server.slots)
+	releaseSlot(connSlots)
 }
 
 // invalidRequest :a placeholder for response argv when recoverable error occurs
@@ -134,9 +722,39 @@ var invalidRequest = struct{}{}
 func (server *Server) serverProcess(cp encode.CodeProcess, opt *Option) {
 	mu := new(sync.Mutex)     // send a complete response
 	wg := new(sync.WaitGroup) // make sure all handleRequest done
+	conn := &serverConn{cp: cp, sending: mu, capabilities: opt.Capabilities}
+	if !server.trackConn(conn, true) {
+		_ = cp.Close()
+		return
+	}
+	defer server.trackConn(conn, false)
+	// cancelled when the connection drops,so handlers can stop early
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := &inflightCalls{
+		cp:      cp,
+		sending: mu,
+		flow:    hasCapability(opt.Capabilities, CapabilityFlowControl),
+		calls:   make(map[uint64]*inflightCall),
+	}
+	// one per request being handled on this connection,nil without MaxConnRequests
+	var connSlots chan struct{}
+	if server.MaxConnRequests > 0 {
+		connSlots = make(chan struct{}, server.MaxConnRequests)
+	}
 	for {
+		header, err := server.readRequestHeader(cp)
+		if err != nil {
+			break
+		}
+		// control messages belong to a request being handled
+		if header.Kind != encode.KindCall {
+			if err := calls.receive(header); err != nil {
+				break
+			}
+			continue
+		}
 		// readRequest
-		req, err := server.readRequest(cp)
+		req, err := server.readRequest(cp, header)
 		if err != nil {
 			// 1. irrecoverable error:break
 			if req == nil {
@@ -148,30 +766,62 @@ func (server *Server) serverProcess(cp encode.CodeProcess, opt *Option) {
 			server.sendResponse(cp, req.header, invalidRequest, mu)
 			continue
 		}
+		if !server.startRequest() {
+			req.header.Error = ErrServerShutdown.Error()
+			server.sendResponse(cp, req.header, invalidRequest, mu)
+			continue
+		}
+		// bound the goroutines a client can make the server spawn
+		if !server.acquireSlots(connSlots) {
+			server.finishRequest()
+			req.header.Error = ErrServerBusy.Error()
+			server.sendResponse(cp, req.header, invalidRequest, mu)
+			continue
+		}
+		// the slots and the count are held until the response is sent and the method returned,
+		// a method outliving its request keeps them
+		pending := new(atomic.Int32)
+		pending.Store(2)
+		finish := func() {
+			if pending.Add(-1) == 0 {
+				server.releaseSlots(connSlots)
+				server.finishRequest()
+			}
+		}
+		req.returned = finish
 		wg.Add(1)
 		// handleRequest
 		// cover sendResponse
-		go server.handleRequest(cp, req, mu, wg, opt.HandleTimeout)
+		go func(req *request, ctx context.Context) {
+			server.handleRequest(ctx, cp, req, mu, wg, opt.HandleTimeout)
+			calls.remove(req.header.Seq)
+			finish()
+		}(req, calls.add(ctx, req))
 	}
+	cancel()
 	wg.Wait()
 	_ = cp.Close()
 }
 
-func (server *Server) readRequest(cp encode.CodeProcess) (*request, error) {
-	header, err := server.readRequestHeader(cp)
-	if err != nil {
-		return nil, err
-	}
+func (server *Server) readRequest(cp encode.CodeProcess, header *encode.Header) (*request, error) {
+	var err error
 	req := &request{header: header}
-
 	req._service, req._method, err = server.findService(header.ServiceMethod)
 
 	if err != nil {
-		return req, nil
+		// skip the body,so the next header can be read
+		if err := cp.ReadBody(nil); err != nil {
+			return nil, err
+		}
+		return req, err
 	}
 
 	req.argv = req._method.newArgv()
 	req.replyv = req._method.newReplyv()
+	// arguments of a client-streaming method follow as stream messages
+	if req._method.clientStream {
+		return req, cp.ReadBody(nil)
+	}
 
 	// ReadBody need pointer as parameter
 	args := req.argv.Interface()
@@ -205,15 +855,73 @@ func (server *Server) sendResponse(cp encode.CodeProcess, header *encode.Header,
 	}
 }
 
-func (server *Server) handleRequest(cp encode.CodeProcess, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+// minTimeout returns the smaller timeout,0 means no timeout
+func minTimeout(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// handleRequest sends exactly one response: the result of the service method,
+// or an error once the timeout fires or the connection drops.
+// ctx passed to context-aware methods is cancelled in both cases.
+func (server *Server) handleRequest(ctx context.Context, cp encode.CodeProcess, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
 	// call method
 	defer wg.Done()
 
-	called := make(chan struct{})
+	info := &RequestInfo{
+		ServiceMethod: req.header.ServiceMethod,
+		Seq:           req.header.Seq,
+		Argv:          req.argv.Interface(),
+		Replyv:        req.replyv.Interface(),
+		Metadata:      req.header.Metadata,
+	}
+	// the request deadline is the earliest of the connection,the client and the server limits
+	timeout = minTimeout(minTimeout(timeout, req.header.Timeout), server.MaxHandleTimeout)
+	// the response reuses the header,don't echo the metadata and timeout
+	req.header.Metadata = nil
+	req.header.Timeout = 0
+	ctx = withIncomingMetadata(ctx, info.Metadata)
+	var cancel context.CancelFunc
+	if timeout == 0 {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	// replies of a streaming method go out before the response ending the stream
+	var stream *serverStream
+	response := req.replyv.Interface()
+	if req._method.stream {
+		stream = &serverStream{ctx: ctx, cp: cp, sending: sending, seq: req.header.Seq, window: req.window}
+		response.(serverStreamer).bind(stream)
+		response = invalidRequest
+		defer stream.close()
+	}
+	// a method still waiting for arguments returns once the request is over
+	if req.queue != nil {
+		defer req.queue.finish(errStreamClosed)
+	}
 
+	// buffered,the method can still finish after nobody waits for it
+	called := make(chan error, 1)
 	go func() {
-		err := req._service.call(req._method, req.argv, req.replyv)
-		called <- struct{}{}
+		err := server.intercept(ctx, info, func(ctx context.Context, info *RequestInfo) error {
+			return req._service.call(ctx, req._method, req.argv, req.replyv)
+		})
+		if req.returned != nil {
+			req.returned()
+		}
+		called <- err
+	}()
+
+	select {
+	case err := <-called:
+		if stream != nil {
+			stream.close()
+		}
 		if err != nil {
 			req.header.Error = err.Error()
 			// send error response
@@ -221,23 +929,18 @@ func (server *Server) handleRequest(cp encode.CodeProcess, req *request, sending
 			return
 		}
 		// send value response
-		server.sendResponse(cp, req.header, req.replyv.Interface(), sending)
-	}()
-
-	if timeout == 0 {
-		<-called
-		// log.Println("call success")
-		return
-	}
-
-	select {
-	case <-time.After(timeout):
-		req.header.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
+		server.sendResponse(cp, req.header, response, sending)
+	case <-ctx.Done():
+		if stream != nil {
+			stream.close()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			req.header.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
+		} else {
+			req.header.Error = "rpc server: request canceled"
+		}
 		server.sendResponse(cp, req.header, invalidRequest, sending)
-	case <-called:
-		log.Println("call success!")
 	}
-	// Todo: 1. why sent is needed? 2. goroutine leak?
 }
 
 // add http
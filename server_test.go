@@ -0,0 +1,507 @@
+package MicroRPC
+
+import (
+	"MicroRPC/encode"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Arith struct{}
+
+type Args struct{ Num1, Num2 int }
+
+func (Arith) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// Metadata replies the value of the request metadata key
+func (Arith) Metadata(ctx context.Context, key string, reply *string) error {
+	*reply = MetadataFromContext(ctx)[key]
+	return nil
+}
+
+// Deadline replies the time left until the deadline of the handler context,0 without one
+func (Arith) Deadline(ctx context.Context, _ int, reply *time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		*reply = time.Until(deadline)
+	}
+	return nil
+}
+
+// Sleeper sleeps for the requested duration,
+// canceled receives ctx.Err() of the sleeps cut short
+type Sleeper struct {
+	canceled chan error
+}
+
+func newSleeper() *Sleeper {
+	return &Sleeper{canceled: make(chan error, 16)}
+}
+
+func (s *Sleeper) Sleep(ctx context.Context, d time.Duration, reply *int) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		s.canceled <- ctx.Err()
+		return ctx.Err()
+	}
+}
+
+// awaitCanceled waits for a sleep of s to be cut short and returns its ctx.Err()
+func (s *Sleeper) awaitCanceled(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-s.canceled:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler context was not cancelled")
+		return nil
+	}
+}
+
+// Blocker blocks until release is closed,ignoring cancellation
+type Blocker struct {
+	release chan struct{}
+}
+
+func (b *Blocker) Block(_ int, reply *int) error {
+	<-b.release
+	return nil
+}
+
+// startServer serves server on a loopback listener and returns its protocol@addr,
+// the server is shut down at the end of the test
+func startServer(t *testing.T, server *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+	return "tcp@" + l.Addr().String()
+}
+
+// shutdown runs server.Shutdown in the background,its result is sent on the returned channel
+func shutdown(server *Server, timeout time.Duration) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+	return done
+}
+
+func TestServerInterceptors(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	var order []string
+	server.Use(func(ctx context.Context, info *RequestInfo, next Handler) error {
+		order = append(order, "outer")
+		return next(ctx, info)
+	}, func(ctx context.Context, info *RequestInfo, next Handler) error {
+		order = append(order, "inner")
+		if info.Argv.(Args).Num1 < 0 {
+			return errors.New("negative")
+		}
+		return next(ctx, info)
+	})
+	client := dial(t, startServer(t, server), nil)
+	var reply int
+	if err := client.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("Sum = %d, %v", reply, err)
+	}
+	if err := client.Call(context.Background(), "Arith.Sum", Args{Num1: -1, Num2: 2}, &reply); err == nil || err.Error() != "negative" {
+		t.Fatalf("Sum = %v, expect the interceptor error", err)
+	}
+	if got := strings.Join(order, ","); got != "outer,inner,outer,inner" {
+		t.Fatalf("order = %s", got)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	var seen map[string]string
+	server.Use(func(ctx context.Context, info *RequestInfo, next Handler) error {
+		seen = info.Metadata
+		return next(ctx, info)
+	})
+	addr := startServer(t, server)
+	for _, encodingType := range []encode.Type{encode.GobType, encode.JsonType, encode.MsgpackType} {
+		t.Run(string(encodingType), func(t *testing.T) {
+			client := dial(t, addr, &Option{EncodingType: encodingType})
+			ctx := WithMetadata(WithMetadata(context.Background(), map[string]string{"user": "u1", "trace": "t1"}), map[string]string{"user": "u2"})
+			var reply string
+			if err := client.Call(ctx, "Arith.Metadata", "user", &reply); err != nil || reply != "u2" {
+				t.Fatalf("Metadata = %q, %v, expect u2", reply, err)
+			}
+			if seen["trace"] != "t1" || seen["user"] != "u2" {
+				t.Fatalf("interceptor saw %v", seen)
+			}
+		})
+	}
+}
+
+func TestHandleTimeoutCancelsHandler(t *testing.T) {
+	server := NewServer()
+	sleeper := newSleeper()
+	_ = server.Register(sleeper)
+	addr := startServer(t, server)
+	client := dial(t, addr, &Option{HandleTimeout: 50 * time.Millisecond})
+	err := client.Call(context.Background(), "Sleeper.Sleep", time.Minute, new(int))
+	if err == nil || !strings.Contains(err.Error(), "handle timeout") {
+		t.Fatalf("Sleep = %v, expect a handle timeout", err)
+	}
+	if err := sleeper.awaitCanceled(t); err != context.DeadlineExceeded {
+		t.Fatalf("handler ctx.Err() = %v, expect %v", err, context.DeadlineExceeded)
+	}
+
+	// the connection drops while the handler runs
+	client = dial(t, addr, nil)
+	client.GoCall("Sleeper.Sleep", time.Minute, new(int), make(chan *Call, 1))
+	time.Sleep(50 * time.Millisecond)
+	_ = client.Close()
+	if err := sleeper.awaitCanceled(t); err != context.Canceled {
+		t.Fatalf("handler ctx.Err() = %v, expect %v", err, context.Canceled)
+	}
+}
+
+func TestCancelPropagation(t *testing.T) {
+	server := NewServer()
+	sleeper := newSleeper()
+	_ = server.Register(sleeper)
+	client := dial(t, startServer(t, server), nil)
+	if !client.Supports(CapabilityCancel) {
+		t.Fatalf("capabilities = %v, expect %s", client.capabilities, CapabilityCancel)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := client.Call(ctx, "Sleeper.Sleep", time.Minute, new(int)); err == nil {
+		t.Fatal("Sleep succeeded, expect the call to be cancelled")
+	}
+	if err := sleeper.awaitCanceled(t); err != context.Canceled {
+		t.Fatalf("handler ctx.Err() = %v, expect %v", err, context.Canceled)
+	}
+	// the connection still serves calls
+	if err := client.Call(context.Background(), "Sleeper.Sleep", time.Millisecond, new(int)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	client := dial(t, startServer(t, server), nil)
+	deadline := func(ctx context.Context) time.Duration {
+		t.Helper()
+		var left time.Duration
+		if err := client.Call(ctx, "Arith.Deadline", 0, &left); err != nil {
+			t.Fatal(err)
+		}
+		return left
+	}
+	if left := deadline(context.Background()); left != 0 {
+		t.Fatalf("deadline without a client deadline = %s, expect none", left)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if left := deadline(ctx); left <= 50*time.Second || left > time.Minute {
+		t.Fatalf("deadline = %s, expect about a minute", left)
+	}
+	// the server caps the client deadline
+	server = NewServer()
+	server.MaxHandleTimeout = time.Second
+	_ = server.Register(new(Arith))
+	client = dial(t, startServer(t, server), nil)
+	if left := deadline(ctx); left <= 0 || left > time.Second {
+		t.Fatalf("deadline = %s, expect at most %s", left, server.MaxHandleTimeout)
+	}
+}
+
+func TestBusyLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		server *Server
+		// second returns the client of the second call
+		second func(t *testing.T, addr string, first *Client) *Client
+	}{
+		{"MaxConnRequests", &Server{MaxConnRequests: 1},
+			func(t *testing.T, addr string, first *Client) *Client { return first }},
+		{"MaxRequests", &Server{MaxRequests: 1},
+			func(t *testing.T, addr string, first *Client) *Client { return dial(t, addr, nil) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sleeper := newSleeper()
+			_ = test.server.Register(sleeper)
+			addr := startServer(t, test.server)
+			first := dial(t, addr, nil)
+			call := first.GoCall("Sleeper.Sleep", 200*time.Millisecond, new(int), make(chan *Call, 1))
+			time.Sleep(50 * time.Millisecond)
+			second := test.second(t, addr, first)
+			if err := second.Call(context.Background(), "Sleeper.Sleep", time.Duration(0), new(int)); err != ErrServerBusy {
+				t.Fatalf("second call = %v, expect %v", err, ErrServerBusy)
+			}
+			if call = <-call.Done; call.Error != nil {
+				t.Fatal(call.Error)
+			}
+			if err := second.Call(context.Background(), "Sleeper.Sleep", time.Duration(0), new(int)); err != nil {
+				t.Fatalf("call after the first returned = %v", err)
+			}
+		})
+	}
+}
+
+func TestBusyWait(t *testing.T) {
+	server := &Server{MaxConnRequests: 1, BusyWait: time.Second}
+	_ = server.Register(newSleeper())
+	client := dial(t, startServer(t, server), nil)
+	call := client.GoCall("Sleeper.Sleep", 100*time.Millisecond, new(int), make(chan *Call, 1))
+	time.Sleep(20 * time.Millisecond)
+	// the reader waits for the first call instead of rejecting the second
+	if err := client.Call(context.Background(), "Sleeper.Sleep", time.Duration(0), new(int)); err != nil {
+		t.Fatalf("second call = %v, expect it to wait for a slot", err)
+	}
+	if call = <-call.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+}
+
+func TestBusyTimedOutMethodKeepsSlot(t *testing.T) {
+	server := &Server{MaxRequests: 1}
+	blocker := &Blocker{release: make(chan struct{})}
+	_ = server.Register(blocker)
+	_ = server.Register(new(Arith))
+	client := dial(t, startServer(t, server), &Option{HandleTimeout: 50 * time.Millisecond})
+	if err := client.Call(context.Background(), "Blocker.Block", 0, new(int)); err == nil {
+		t.Fatal("Block succeeded, expect a handle timeout")
+	}
+	// Block ignores its context and still runs after the response
+	time.Sleep(50 * time.Millisecond)
+	var reply int
+	if err := client.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != ErrServerBusy {
+		t.Fatalf("Sum = %v while Block runs, expect %v", err, ErrServerBusy)
+	}
+	close(blocker.release)
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		err := client.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		if err == nil {
+			break
+		}
+		if err != ErrServerBusy || time.Since(start) > 5*time.Second {
+			t.Fatalf("Sum = %v after Block returned", err)
+		}
+	}
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(newSleeper())
+	client := dial(t, startServer(t, server), nil)
+	call := client.GoCall("Sleeper.Sleep", 100*time.Millisecond, new(int), make(chan *Call, 1))
+	for server.active.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	done := shutdown(server, time.Second)
+	if call = <-call.Done; call.Error != nil {
+		t.Fatal("call in flight:", call.Error)
+	}
+	if err := <-done; err != nil {
+		t.Fatal("shutdown:", err)
+	}
+	if err := client.Call(context.Background(), "Sleeper.Sleep", time.Duration(0), new(int)); err == nil {
+		t.Fatal("call after shutdown succeeded")
+	}
+}
+
+func TestShutdownWaitsForTimedOutMethod(t *testing.T) {
+	server := NewServer()
+	blocker := &Blocker{release: make(chan struct{})}
+	defer close(blocker.release)
+	_ = server.Register(blocker)
+	client := dial(t, startServer(t, server), &Option{HandleTimeout: 20 * time.Millisecond})
+	if err := client.Call(context.Background(), "Blocker.Block", 0, new(int)); err == nil {
+		t.Fatal("Block succeeded, expect a handle timeout")
+	}
+	// the response is sent but Block still runs
+	if err := <-shutdown(server, 100*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("shutdown = %v, expect %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestShutdownWaitsForOnShutdown(t *testing.T) {
+	server := NewServer()
+	var ran atomic.Bool
+	server.RegisterOnShutdown(func() {
+		time.Sleep(50 * time.Millisecond)
+		ran.Store(true)
+	})
+	if err := <-shutdown(server, time.Second); err != nil || !ran.Load() {
+		t.Fatalf("shutdown = %v, ran = %v, expect it to wait for the function", err, ran.Load())
+	}
+
+	server = NewServer()
+	stuck := make(chan struct{})
+	defer close(stuck)
+	server.RegisterOnShutdown(func() { <-stuck })
+	if err := <-shutdown(server, 50*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("shutdown = %v, expect %v", err, context.DeadlineExceeded)
+	}
+}
+
+// waitDraining waits until client got encode.KindGoAway
+func waitDraining(t *testing.T, client *Client) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !client.IsDraining() {
+		if time.Now().After(deadline) {
+			t.Fatal("client didn't get goaway")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShutdownIdleClient(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	client := dial(t, startServer(t, server), nil)
+	var reply int
+	if err := client.Call(context.Background(), "Arith.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("Sum = %d, %v", reply, err)
+	}
+	// the drained client closes its connection,Shutdown doesn't wait for ctx
+	if err := <-shutdown(server, time.Second); err != nil {
+		t.Fatal("shutdown:", err)
+	}
+	if client.IsAvailable() {
+		t.Fatal("client still available after shutdown")
+	}
+}
+
+func TestShutdownAnswersLateRequests(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Arith))
+	addr := startServer(t, server)
+	conn, err := net.Dial("tcp", addr[len("tcp@"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	option := *DefaultOption
+	if err := json.NewEncoder(conn).Encode(&option); err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(conn)
+	var negotiation Negotiation
+	if err := decoder.Decode(&negotiation); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := newCodeProcess(newHandshakeConn(conn, decoder), &option)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := shutdown(server, time.Second)
+	var h encode.Header
+	if err := cp.ReadHeader(&h); err != nil || h.Kind != encode.KindGoAway {
+		t.Fatalf("read goaway: %+v, %v", h, err)
+	}
+	_ = cp.ReadBody(nil)
+	// a request sent before the client saw the goaway
+	if err := cp.Write(&encode.Header{ServiceMethod: "Arith.Sum", Seq: 1}, Args{Num1: 1, Num2: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.ReadHeader(&h); err != nil || h.Seq != 1 || h.Error != ErrServerShutdown.Error() {
+		t.Fatalf("read response: %+v, %v", h, err)
+	}
+	_ = cp.ReadBody(nil)
+	_ = cp.Close()
+	if err := <-done; err != nil {
+		t.Fatal("shutdown:", err)
+	}
+}
+
+func TestShutdownDrainsClientStream(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Streams))
+	client := dial(t, startServer(t, server), nil)
+	up, err := CallClientStream[int, int](context.Background(), client, "Streams.Sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := up.Send(1); err != nil {
+		t.Fatal(err)
+	}
+	// the request has to be handled before Shutdown starts
+	for server.active.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	done := shutdown(server, time.Second)
+	waitDraining(t, client)
+	if err := up.Send(2); err != nil {
+		t.Fatal("send while draining:", err)
+	}
+	if sum, err := up.CloseAndRecv(); err != nil || sum != 3 {
+		t.Fatalf("CloseAndRecv = %d, %v", sum, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal("shutdown:", err)
+	}
+}
+
+func TestShutdownDrainsServerStream(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Streams))
+	client := dial(t, startServer(t, server), nil)
+	// more replies than fit in the window,the rest needs credit granted while draining
+	const n = 4 * streamWindow
+	stream, err := CallStream[int](context.Background(), client, "Streams.Count", n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	done := shutdown(server, time.Second)
+	waitDraining(t, client)
+	count := 1
+	for _, err := range stream.All() {
+		if err != nil {
+			t.Fatal("recv while draining:", err)
+		}
+		count++
+	}
+	if count != n {
+		t.Fatalf("received %d replies, expect %d", count, n)
+	}
+	if err := <-done; err != nil {
+		t.Fatal("shutdown:", err)
+	}
+}
+
+func TestServices(t *testing.T) {
+	server := NewServer()
+	if names := server.Services(); len(names) != 0 {
+		t.Fatalf("services = %v, expect none", names)
+	}
+	_ = server.Register(newSleeper())
+	_ = server.Register(new(Arith))
+	if names := server.Services(); !reflect.DeepEqual(names, []string{"Arith", "Sleeper"}) {
+		t.Fatalf("services = %v, expect Arith,Sleeper", names)
+	}
+}
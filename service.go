@@ -1,17 +1,23 @@
 package MicroRPC
 
 import (
+	"context"
 	"go/ast"
 	"log"
 	"reflect"
 	"sync/atomic"
+
+	"google.golang.org/protobuf/proto"
 )
 
 type method struct {
-	_method   reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalled uint64
+	_method      reflect.Method
+	ArgType      reflect.Type
+	ReplyType    reflect.Type
+	numCalled    uint64
+	withContext  bool // func (T) M(ctx context.Context, args, *reply) error
+	stream       bool // func (T) M(args, *ServerStream[R]) error
+	clientStream bool // func (T) M(*ClientStream[A], reply) error
 }
 
 func (m *method) NumCalled() uint64 {
@@ -45,15 +51,17 @@ func (m *method) newReplyv() reflect.Value {
 }
 
 type service struct {
-	name     string
-	_type    reflect.Type
-	instance reflect.Value
-	methods  map[string]*method // key: method.Name
+	name      string
+	_type     reflect.Type
+	instance  reflect.Value
+	methods   map[string]*method // key: method.Name
+	protoOnly bool               // only register methods whose argv and replyv,or their stream messages,are proto.Message
 }
 
 // newService :make sure instance a pointer to set value
-func newService(instance interface{}) *service {
+func newService(instance interface{}, protoOnly bool) *service {
 	s := new(service)
+	s.protoOnly = protoOnly
 	s.instance = reflect.ValueOf(instance)
 	// get value of a pointer,use Indirect().Type()
 	// To get name, get value of the pointer
@@ -71,22 +79,30 @@ func (s *service) registerMethods() {
 	for i := 0; i < s._type.NumMethod(); i++ {
 		m := s._type.Method(i)
 		mType := m.Type
-		// reflect argv including instance
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		// reflect argv including instance,optionally a context.Context first
+		withContext := mType.NumIn() == 4 && mType.In(1) == contextType
+		if (mType.NumIn() != 3 && !withContext) || mType.NumOut() != 1 {
 			continue
 		}
 		// Todo:why *error.Elem() instead of error
 		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
+		argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
+		if s.protoOnly && (!isProtoMessageType(messageType(argType)) || !isProtoMessageType(messageType(replyType))) {
+			log.Printf("rpc server: reject %s.%s: proto-only service needs proto.Message argv and replyv\n", s.name, m.Name)
+			continue
+		}
 		s.methods[m.Name] = &method{
-			_method:   m,
-			ArgType:   argType,
-			ReplyType: replyType,
+			_method:      m,
+			ArgType:      argType,
+			ReplyType:    replyType,
+			withContext:  withContext,
+			stream:       replyType.Implements(serverStreamerType),
+			clientStream: argType.Implements(clientStreamerType),
 		}
 		log.Printf("rpc server: register %s.%s\n", s.name, m.Name)
 	}
@@ -96,10 +112,34 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-func (s *service) call(m *method, argv, replyv reflect.Value) error {
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// messageType returns the type of the messages of a stream argv or replyv,else t
+func messageType(t reflect.Type) reflect.Type {
+	switch {
+	case t.Implements(serverStreamerType):
+		return reflect.Zero(t).Interface().(serverStreamer).messageType()
+	case t.Implements(clientStreamerType):
+		return reflect.Zero(t).Interface().(clientStreamer).messageType()
+	}
+	return t
+}
+
+func isProtoMessageType(t reflect.Type) bool {
+	return t.Implements(protoMessageType)
+}
+
+// call :ctx is only passed to methods taking a context.Context
+func (s *service) call(ctx context.Context, m *method, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalled, 1)
 	f := m._method.Func
-	returnValues := f.Call([]reflect.Value{s.instance, argv, replyv})
+	in := []reflect.Value{s.instance, argv, replyv}
+	if m.withContext {
+		in = []reflect.Value{s.instance, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	if errInterface := returnValues[0].Interface(); errInterface != nil {
 		return errInterface.(error)
 	}
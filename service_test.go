@@ -0,0 +1,32 @@
+package MicroRPC
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type ProtoEcho struct{}
+
+func (ProtoEcho) Echo(args *wrapperspb.StringValue, reply *wrapperspb.StringValue) error {
+	reply.Value = args.Value
+	return nil
+}
+
+func (ProtoEcho) Len(args string, reply *int) error {
+	*reply = len(args)
+	return nil
+}
+
+func TestRegisterProto(t *testing.T) {
+	s := newService(new(ProtoEcho), true)
+	if s.methods["Echo"] == nil {
+		t.Fatal("proto method Echo not registered")
+	}
+	if s.methods["Len"] != nil {
+		t.Fatal("non-proto method Len registered")
+	}
+	if s := newService(new(ProtoEcho), false); len(s.methods) != 2 {
+		t.Fatalf("%d methods registered, expect 2", len(s.methods))
+	}
+}
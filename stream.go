@@ -0,0 +1,518 @@
+package MicroRPC
+
+import (
+	"MicroRPC/encode"
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// Streaming methods take a *ClientStream[T1] instead of the argument and/or
+// a *ServerStream[T2] instead of the reply, optionally with a context.Context first:
+//
+//	func (t *T) MethodName(argType T1, stream *ServerStream[T2]) error      // server-streaming
+//	func (t *T) MethodName(stream *ClientStream[T1], replyType *T2) error   // client-streaming
+//	func (t *T) MethodName(in *ClientStream[T1], out *ServerStream[T2]) error // bidirectional
+//
+// Every message of a stream is an encode.KindStream message with the Seq of the request,
+// the client ends its side with encode.KindStreamEnd and the usual response ends the call.
+// With CapabilityFlowControl a sender has at most streamWindow messages of a stream in flight,
+// the receiver grants more with encode.KindWindow as they are read, so a slow stream
+// only blocks its own sender.
+
+// streamWindow is the number of messages a stream sender may have in flight
+const streamWindow = 64
+
+var errStreamClosed = errors.New("rpc: stream closed")
+
+// sendWindow blocks the sender of a stream until the receiver has room,
+// a nil sendWindow never blocks
+type sendWindow struct {
+	wake   chan struct{} // signals granted credit or close
+	mu     sync.Mutex    // protect following
+	credit int
+	closed bool
+}
+
+func newSendWindow() *sendWindow {
+	return &sendWindow{credit: streamWindow, wake: make(chan struct{}, 1)}
+}
+
+func (w *sendWindow) acquire(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	for {
+		w.mu.Lock()
+		closed, ok := w.closed, w.credit > 0
+		if ok {
+			w.credit--
+		}
+		more := w.closed || w.credit > 0
+		w.mu.Unlock()
+		// pass the wake up on to other waiting senders
+		if more {
+			w.signal()
+		}
+		if closed {
+			return errStreamClosed
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-w.wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *sendWindow) grant(credit int) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.credit += credit
+	w.mu.Unlock()
+	w.signal()
+}
+
+// close wakes up waiting senders once the call is over
+func (w *sendWindow) close() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	w.signal()
+}
+
+func (w *sendWindow) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// streamQueue holds the received messages of a stream until they are read,
+// read messages are granted back to the sender in batches
+type streamQueue struct {
+	wake     chan struct{}    // signals a pushed message or the end of the stream
+	grant    func(credit int) // nil: the sender isn't flow controlled
+	mu       sync.Mutex       // protect following
+	messages []interface{}
+	consumed int // read since the last grant
+	finished bool
+	err      error // io.EOF once the stream ended without error
+}
+
+func newStreamQueue(grant func(credit int)) *streamQueue {
+	return &streamQueue{wake: make(chan struct{}, 1), grant: grant}
+}
+
+func (q *streamQueue) push(message interface{}) {
+	q.mu.Lock()
+	q.messages = append(q.messages, message)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *streamQueue) finish(err error) {
+	q.mu.Lock()
+	if !q.finished {
+		q.finished = true
+		q.err = err
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *streamQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next message,or the error ending the stream once the queue is drained.
+// ok is false while neither is available.
+func (q *streamQueue) next() (message interface{}, ok bool, err error) {
+	q.mu.Lock()
+	if len(q.messages) == 0 {
+		defer q.mu.Unlock()
+		return nil, q.finished, q.err
+	}
+	message = q.messages[0]
+	q.messages = q.messages[1:]
+	var credit int
+	if q.consumed++; q.grant != nil && q.consumed >= streamWindow/2 {
+		credit, q.consumed = q.consumed, 0
+	}
+	q.mu.Unlock()
+	// grant outside the lock,it writes to the connection
+	if credit > 0 {
+		q.grant(credit)
+	}
+	return message, true, nil
+}
+
+// newStreamMessage returns what a message of a stream of T is decoded into:
+// a new T when T is a pointer,so proto messages are decoded in place,else a *T
+func newStreamMessage[T any]() interface{} {
+	if t := reflect.TypeFor[T](); t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface()
+	}
+	return new(T)
+}
+
+// streamMessage returns the T decoded into message by newStreamMessage
+func streamMessage[T any](message interface{}) T {
+	if m, ok := message.(*T); ok {
+		return *m
+	}
+	return message.(T)
+}
+
+// serverStreamer is implemented by *ServerStream[R] of any R
+type serverStreamer interface {
+	bind(stream *serverStream)
+	messageType() reflect.Type
+}
+
+var serverStreamerType = reflect.TypeOf((*serverStreamer)(nil)).Elem()
+
+// serverStream sends the replies of one request
+type serverStream struct {
+	ctx     context.Context
+	cp      encode.CodeProcess
+	sending *sync.Mutex // shared with sendResponse
+	seq     uint64
+	window  *sendWindow // nil without flow control
+	mu      sync.Mutex  // protect closed,no reply is sent after the response
+	closed  bool
+}
+
+func (s *serverStream) send(reply interface{}) error {
+	// wait for room before locking,the response must not wait for a slow client
+	if err := s.window.acquire(s.ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	return s.cp.Write(&encode.Header{Seq: s.seq, Kind: encode.KindStream}, reply)
+}
+
+func (s *serverStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// ServerStream sends a stream of replies to the client
+type ServerStream[R any] struct {
+	stream *serverStream
+}
+
+func (s *ServerStream[R]) bind(stream *serverStream) {
+	s.stream = stream
+}
+
+func (s *ServerStream[R]) messageType() reflect.Type {
+	return reflect.TypeFor[R]()
+}
+
+// Send sends one reply,it blocks while the client has no room for it
+// and fails once the request is cancelled or has returned
+func (s *ServerStream[R]) Send(reply R) error {
+	return s.stream.send(reply)
+}
+
+// clientStreamer is implemented by *ClientStream[A] of any A
+type clientStreamer interface {
+	bind(queue *streamQueue)
+	newMessage() interface{}
+	messageType() reflect.Type
+}
+
+var clientStreamerType = reflect.TypeOf((*clientStreamer)(nil)).Elem()
+
+// ClientStream receives a stream of arguments from the client
+type ClientStream[A any] struct {
+	queue *streamQueue
+}
+
+func (s *ClientStream[A]) bind(queue *streamQueue) {
+	s.queue = queue
+}
+
+func (s *ClientStream[A]) newMessage() interface{} {
+	return newStreamMessage[A]()
+}
+
+func (s *ClientStream[A]) messageType() reflect.Type {
+	return reflect.TypeFor[A]()
+}
+
+// Recv returns the next argument,io.EOF once the client has closed its side
+func (s *ClientStream[A]) Recv() (A, error) {
+	var zero A
+	for {
+		message, ok, err := s.queue.next()
+		if !ok {
+			<-s.queue.wake
+			continue
+		}
+		if message == nil {
+			return zero, err
+		}
+		return streamMessage[A](message), nil
+	}
+}
+
+// streamReceiver takes the replies of a streaming call from the receive loop
+type streamReceiver interface {
+	newMessage() interface{}
+	push(message interface{})
+}
+
+// ReplyStream receives the replies of a server-streaming or bidirectional call
+type ReplyStream[R any] struct {
+	ctx    context.Context
+	client *Client
+	call   *Call
+	queue  *streamQueue
+}
+
+func newReplyStream[R any](ctx context.Context, client *Client) *ReplyStream[R] {
+	s := &ReplyStream[R]{ctx: ctx, client: client}
+	var grant func(credit int)
+	if client.Supports(CapabilityFlowControl) {
+		grant = func(credit int) {
+			header := &encode.Header{Seq: s.call.Seq, Kind: encode.KindWindow, Credit: uint32(credit)}
+			if err := client.sendMessage(header, struct{}{}); err != nil {
+				log.Println("rpc client: send window error:", err)
+			}
+		}
+	}
+	s.queue = newStreamQueue(grant)
+	return s
+}
+
+func (s *ReplyStream[R]) newMessage() interface{} {
+	return newStreamMessage[R]()
+}
+
+func (s *ReplyStream[R]) push(message interface{}) {
+	s.queue.push(message)
+}
+
+// Recv returns the next reply,io.EOF after the last one
+func (s *ReplyStream[R]) Recv() (R, error) {
+	var zero R
+	for {
+		// replies arrive before the response ending the stream
+		if message, ok, err := s.queue.next(); ok {
+			if message == nil {
+				return zero, err
+			}
+			return streamMessage[R](message), nil
+		}
+		select {
+		case <-s.queue.wake:
+		case call := <-s.call.Done:
+			if call.Error != nil {
+				s.queue.finish(call.Error)
+			} else {
+				s.queue.finish(io.EOF)
+			}
+		case <-s.ctx.Done():
+			s.Close()
+			return zero, errors.New("rpc client: stream failed: " + s.ctx.Err().Error())
+		}
+	}
+}
+
+// All iterates over the replies,stopping at the end of the stream or at the first error
+func (s *ReplyStream[R]) All() iter.Seq2[R, error] {
+	return func(yield func(R, error) bool) {
+		for {
+			reply, err := s.Recv()
+			if err == io.EOF {
+				return
+			}
+			if !yield(reply, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close stops receiving,the server is told to cancel the request if it is still running
+func (s *ReplyStream[R]) Close() {
+	s.queue.finish(errStreamClosed)
+	s.client.cancelCall(s.call.Seq)
+}
+
+// CallStream invokes a server-streaming method,replies are read from the returned stream
+func CallStream[R any](ctx context.Context, client *Client, serviceMethod string, args interface{}) (*ReplyStream[R], error) {
+	if !client.Supports(CapabilityStream) {
+		return nil, errors.New("rpc client: server does not support " + CapabilityStream)
+	}
+	stream := newReplyStream[R](ctx, client)
+	stream.call = client.newCall(ctx, serviceMethod, args, nil)
+	stream.call.stream = stream
+	client.send(stream.call)
+	return stream, nil
+}
+
+// argumentStream sends the arguments of a client-streaming or bidirectional call
+type argumentStream[A any] struct {
+	ctx    context.Context
+	client *Client
+	call   *Call
+	mu     sync.Mutex // protect closed
+	closed bool
+}
+
+func (s *argumentStream[A]) write(header *encode.Header, body interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.client.findCall(s.call.Seq) == nil {
+		return errStreamClosed
+	}
+	return s.client.sendMessage(header, body)
+}
+
+func (s *argumentStream[A]) send(arg A) error {
+	// wait for room before taking the send lock,other calls go on meanwhile
+	if err := s.call.window.acquire(s.ctx); err != nil {
+		return err
+	}
+	return s.write(&encode.Header{Seq: s.call.Seq, Kind: encode.KindStream}, arg)
+}
+
+func (s *argumentStream[A]) closeSend() error {
+	if err := s.write(&encode.Header{Seq: s.call.Seq, Kind: encode.KindStreamEnd}, struct{}{}); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// openStream sends the request of a client-streaming or bidirectional call,
+// the arguments follow on the returned stream
+func openStream[A any](ctx context.Context, client *Client, serviceMethod string, reply interface{}, receiver streamReceiver) (*argumentStream[A], error) {
+	if !client.Supports(CapabilityClientStream) {
+		return nil, errors.New("rpc client: server does not support " + CapabilityClientStream)
+	}
+	call := client.newCall(ctx, serviceMethod, struct{}{}, reply)
+	call.stream = receiver
+	if client.Supports(CapabilityFlowControl) {
+		call.window = newSendWindow()
+	}
+	client.send(call)
+	return &argumentStream[A]{ctx: ctx, client: client, call: call}, nil
+}
+
+// UploadStream is the client side of a client-streaming call
+type UploadStream[A, R any] struct {
+	args  *argumentStream[A]
+	reply interface{} // see newStreamMessage
+}
+
+// Send sends one argument,it blocks while the server has no room for it
+func (s *UploadStream[A, R]) Send(arg A) error {
+	return s.args.send(arg)
+}
+
+// CloseAndRecv closes the sending side and waits for the reply
+func (s *UploadStream[A, R]) CloseAndRecv() (R, error) {
+	var zero R
+	// errStreamClosed: the call already ended,its error is in Done
+	if err := s.args.closeSend(); err != nil && err != errStreamClosed {
+		return zero, err
+	}
+	select {
+	case call := <-s.args.call.Done:
+		if call.Error != nil {
+			return zero, call.Error
+		}
+		return streamMessage[R](s.reply), nil
+	case <-s.args.ctx.Done():
+		s.Close()
+		return zero, errors.New("rpc client: stream failed: " + s.args.ctx.Err().Error())
+	}
+}
+
+// Close gives up on the call,the server is told to cancel the request if it is still running
+func (s *UploadStream[A, R]) Close() {
+	s.args.client.cancelCall(s.args.call.Seq)
+}
+
+// CallClientStream invokes a client-streaming method,arguments are sent on the returned stream
+func CallClientStream[A, R any](ctx context.Context, client *Client, serviceMethod string) (*UploadStream[A, R], error) {
+	reply := newStreamMessage[R]()
+	args, err := openStream[A](ctx, client, serviceMethod, reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadStream[A, R]{args: args, reply: reply}, nil
+}
+
+// BidiStream is the client side of a bidirectional streaming call
+type BidiStream[A, R any] struct {
+	args    *argumentStream[A]
+	replies *ReplyStream[R]
+}
+
+// Send sends one argument,it blocks while the server has no room for it
+func (s *BidiStream[A, R]) Send(arg A) error {
+	return s.args.send(arg)
+}
+
+// CloseSend tells the server no more arguments follow,replies can still be received
+func (s *BidiStream[A, R]) CloseSend() error {
+	return s.args.closeSend()
+}
+
+// Recv returns the next reply,io.EOF after the last one
+func (s *BidiStream[A, R]) Recv() (R, error) {
+	return s.replies.Recv()
+}
+
+// All iterates over the replies,stopping at the end of the stream or at the first error
+func (s *BidiStream[A, R]) All() iter.Seq2[R, error] {
+	return s.replies.All()
+}
+
+// Close gives up on the call,the server is told to cancel the request if it is still running
+func (s *BidiStream[A, R]) Close() {
+	s.replies.Close()
+}
+
+// CallBidiStream invokes a bidirectional streaming method
+func CallBidiStream[A, R any](ctx context.Context, client *Client, serviceMethod string) (*BidiStream[A, R], error) {
+	replies := newReplyStream[R](ctx, client)
+	args, err := openStream[A](ctx, client, serviceMethod, nil, replies)
+	if err != nil {
+		return nil, err
+	}
+	replies.call = args.call
+	return &BidiStream[A, R]{args: args, replies: replies}, nil
+}
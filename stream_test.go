@@ -0,0 +1,167 @@
+package MicroRPC
+
+import (
+	"MicroRPC/encode"
+	"context"
+	"io"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Streams struct{}
+
+func (Streams) Count(n int, stream *ServerStream[int]) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (Streams) Sum(stream *ClientStream[int], reply *int) error {
+	for {
+		arg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		*reply += arg
+	}
+}
+
+func (Streams) Echo(in *ClientStream[int], out *ServerStream[int]) error {
+	for {
+		arg, err := in.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := out.Send(arg); err != nil {
+			return err
+		}
+	}
+}
+
+// ProtoStreams is Streams with proto messages,for encode.ProtobufType
+type ProtoStreams struct{}
+
+func (ProtoStreams) Count(n *wrapperspb.Int32Value, stream *ServerStream[*wrapperspb.Int32Value]) error {
+	for i := int32(0); i < n.Value; i++ {
+		if err := stream.Send(wrapperspb.Int32(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ProtoStreams) Sum(stream *ClientStream[*wrapperspb.Int32Value], reply *wrapperspb.Int32Value) error {
+	for {
+		arg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply.Value += arg.Value
+	}
+}
+
+func (ProtoStreams) Echo(in *ClientStream[*wrapperspb.Int32Value], out *ServerStream[*wrapperspb.Int32Value]) error {
+	for {
+		arg, err := in.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := out.Send(arg); err != nil {
+			return err
+		}
+	}
+}
+
+// testStreams runs every kind of stream of service,n is past the flow control window
+func testStreams[T any](t *testing.T, client *Client, service string, wrap func(int) T, unwrap func(T) int) {
+	const n = 2*streamWindow + 1
+	ctx := context.Background()
+
+	replies, err := CallStream[T](ctx, client, service+".Count", wrap(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for reply, err := range replies.All() {
+		if err != nil {
+			t.Fatal("Count:", err)
+		}
+		if unwrap(reply) != count {
+			t.Fatalf("Count: reply %d, expect %d", unwrap(reply), count)
+		}
+		count++
+	}
+	if count != n {
+		t.Fatalf("Count: %d replies, expect %d", count, n)
+	}
+
+	up, err := CallClientStream[T, T](ctx, client, service+".Sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := up.Send(wrap(i)); err != nil {
+			t.Fatal("Sum:", err)
+		}
+	}
+	if sum, err := up.CloseAndRecv(); err != nil || unwrap(sum) != n*(n-1)/2 {
+		t.Fatalf("Sum = %v, %v", sum, err)
+	}
+
+	bidi, err := CallBidiStream[T, T](ctx, client, service+".Echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := bidi.Send(wrap(i)); err != nil {
+			t.Fatal("Echo:", err)
+		}
+		if reply, err := bidi.Recv(); err != nil || unwrap(reply) != i {
+			t.Fatalf("Echo = %v, %v", reply, err)
+		}
+	}
+	if err := bidi.CloseSend(); err != nil {
+		t.Fatal("Echo:", err)
+	}
+	if _, err := bidi.Recv(); err != io.EOF {
+		t.Fatalf("Echo: %v, expect io.EOF", err)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Streams))
+	_ = server.RegisterProto(new(ProtoStreams))
+	addr := startServer(t, server)
+
+	for _, encodingType := range []encode.Type{encode.GobType, encode.JsonType, encode.MsgpackType} {
+		t.Run(string(encodingType), func(t *testing.T) {
+			client := dial(t, addr, &Option{EncodingType: encodingType})
+			testStreams(t, client, "Streams",
+				func(i int) int { return i },
+				func(i int) int { return i })
+		})
+	}
+	t.Run(string(encode.ProtobufType), func(t *testing.T) {
+		client := dial(t, addr, &Option{EncodingType: encode.ProtobufType})
+		testStreams(t, client, "ProtoStreams",
+			func(i int) *wrapperspb.Int32Value { return wrapperspb.Int32(int32(i)) },
+			func(v *wrapperspb.Int32Value) int { return int(v.GetValue()) })
+	})
+}